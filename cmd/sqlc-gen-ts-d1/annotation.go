@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// QueryAnnotations はクエリのコメントに書かれたアノテーションの情報
+//
+// アノテーションは `-- name:` の上のコメントで `@` から始まる行に書く
+//
+//	-- @returns scalar|row
+//	-- @param <パラメータ名>: <TypeScript の型>
+//	-- @deprecated <メッセージ>
+//	-- @readonly
+//	-- @nullable-embed <sqlc.embed のテーブル名>
+//	-- @cache ttl=<秒> [key=<パラメータ名>,...]
+//	-- @loader
+//	-- @paginate offset|keyset=<カラム名> [limit=<パラメータ名>] [offset=<パラメータ名>] [cursor=<パラメータ名>]
+type QueryAnnotations struct {
+	// returns は結果の返し方で、"scalar" は値で返し "row" はオブジェクトで返す。空の場合はオプションに従う
+	returns string
+	// paramTypes はパラメータのプロパティ名から TypeScript の型への対応
+	paramTypes map[string]string
+	// deprecated は関数が非推奨かどうかとその理由
+	deprecated        bool
+	deprecatedMessage string
+	// readonly はクエリがデータを変更しないことを表し、SQL から判定した結果より優先する
+	readonly bool
+	// nullableEmbeds は LEFT JOIN などで null になりうる sqlc.embed のテーブル名
+	nullableEmbeds map[string]bool
+	// cache は結果をキャッシュする場合の設定
+	cache *cacheAnnotation
+	// loader は sqlc.slice の要素ごとに呼び出せる Loader を生成するかどうか
+	loader bool
+	// paginate はページごとに結果を返す関数を生成する場合の設定
+	paginate *paginateAnnotation
+}
+
+// cacheAnnotation は @cache アノテーションの設定
+type cacheAnnotation struct {
+	// ttl はキャッシュの有効期間の秒数
+	ttl int
+	// keys はキャッシュのキーに使うパラメータのプロパティ名の順番で、nil の場合はパラメータの順番になる
+	// 異なる引数で同じキャッシュを使わないように全てのパラメータを含める
+	keys []string
+}
+
+// isAnnotation はコメントの行がアノテーションかどうかを返す
+func isAnnotation(comment string) bool {
+	return strings.HasPrefix(strings.TrimSpace(comment), "@")
+}
+
+// parseAnnotations はクエリのコメントからアノテーションを読み取る
+// 不明なアノテーションや書式の誤りはクエリのファイル名と名前を含むエラーにする
+func parseAnnotations(q *plugin.Query) (*QueryAnnotations, error) {
+	a := &QueryAnnotations{
+		paramTypes:     map[string]string{},
+		nullableEmbeds: map[string]bool{},
+	}
+	seen := map[string]bool{}
+	for _, comment := range q.GetComments() {
+		if !isAnnotation(comment) {
+			continue
+		}
+		line := strings.TrimSpace(comment)
+		name, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		errorf := func(format string, args ...any) error {
+			return fmt.Errorf("%s: %s: %s: %s", q.GetFilename(), q.GetName(), name, fmt.Sprintf(format, args...))
+		}
+		// @param と @nullable-embed は対象ごとに複数書ける
+		if seen[name] && name != "@param" && name != "@nullable-embed" {
+			return nil, errorf("duplicated annotation")
+		}
+		seen[name] = true
+
+		switch name {
+		case "@returns":
+			if arg != "scalar" && arg != "row" {
+				return nil, errorf("must be scalar or row: %q", arg)
+			}
+			if arg == "scalar" && !isScalarQuery(q) {
+				return nil, errorf("query must be :one or :many and return exactly one non-embed column")
+			}
+			a.returns = arg
+		case "@param":
+			param, tsType, ok := strings.Cut(arg, ":")
+			param = strings.TrimSpace(param)
+			tsType = strings.TrimSpace(tsType)
+			if !ok || param == "" || tsType == "" {
+				return nil, errorf("must be `@param <name>: <type>`: %q", arg)
+			}
+			propName := findParamProperty(q, param)
+			if propName == "" {
+				return nil, errorf("parameter not found: %s", param)
+			}
+			if _, ok := a.paramTypes[propName]; ok {
+				return nil, errorf("duplicated parameter: %s", param)
+			}
+			a.paramTypes[propName] = tsType
+		case "@deprecated":
+			a.deprecated = true
+			a.deprecatedMessage = arg
+		case "@readonly":
+			if arg != "" {
+				return nil, errorf("unexpected argument: %q", arg)
+			}
+			if isWriteStatement(q.GetText()) {
+				return nil, errorf("query modifies data")
+			}
+			a.readonly = true
+		case "@nullable-embed":
+			found := false
+			for _, c := range q.GetColumns() {
+				if c.GetEmbedTable().GetName() != "" && c.GetName() == arg {
+					found = true
+				}
+			}
+			if !found {
+				return nil, errorf("sqlc.embed not found: %q", arg)
+			}
+			a.nullableEmbeds[arg] = true
+		case "@cache":
+			if cmd := q.GetCmd(); cmd != ":one" && cmd != ":many" {
+				return nil, errorf("query must be :one or :many and must not modify data")
+			}
+			a.cache = &cacheAnnotation{}
+			for _, kv := range strings.Fields(arg) {
+				k, v, _ := strings.Cut(kv, "=")
+				switch k {
+				case "ttl":
+					ttl, err := strconv.Atoi(v)
+					if err != nil || ttl <= 0 {
+						return nil, errorf("ttl must be a positive integer: %q", v)
+					}
+					a.cache.ttl = ttl
+				case "key":
+					keys := map[string]bool{}
+					for _, param := range strings.Split(v, ",") {
+						propName := findParamProperty(q, param)
+						if propName == "" {
+							return nil, errorf("parameter not found: %s", param)
+						}
+						if keys[propName] {
+							return nil, errorf("duplicated parameter: %s", param)
+						}
+						keys[propName] = true
+						a.cache.keys = append(a.cache.keys, propName)
+					}
+					var missing []string
+					for _, p := range q.GetParams() {
+						if propName := naming.toPropertyName(p.GetColumn()); !keys[propName] {
+							missing = append(missing, propName)
+						}
+					}
+					if len(missing) > 0 {
+						return nil, errorf("key must include every parameter, missing: %s", strings.Join(missing, ", "))
+					}
+				default:
+					return nil, errorf("unknown option: %q", kv)
+				}
+			}
+			if a.cache.ttl == 0 {
+				return nil, errorf("ttl is required")
+			}
+		case "@loader":
+			if arg != "" {
+				return nil, errorf("unexpected argument: %q", arg)
+			}
+			if param, _ := loaderKey(q, nil); param == nil {
+				return nil, errorf("query must be :many with a single sqlc.slice parameter and return the column of the parameter")
+			}
+			a.loader = true
+		case "@paginate":
+			p, err := parsePaginate(q, arg)
+			if err != nil {
+				return nil, errorf("%s", err)
+			}
+			a.paginate = p
+		default:
+			return nil, fmt.Errorf("%s: %s: unknown annotation: %s", q.GetFilename(), q.GetName(), name)
+		}
+	}
+	// @readonly はどの順番でも書けるので全てのアノテーションを読んでから調べる
+	if a.cache != nil && !a.isReadOnly(q) {
+		return nil, fmt.Errorf("%s: %s: @cache: query must be :one or :many and must not modify data", q.GetFilename(), q.GetName())
+	}
+	if a.loader && a.returns == "scalar" {
+		return nil, fmt.Errorf("%s: %s: @loader: query must not return scalar", q.GetFilename(), q.GetName())
+	}
+	return a, nil
+}
+
+// isReadOnly はクエリがデータを変更しないかどうかを返す
+// SQL から判定できない PRAGMA などのクエリは @readonly で読み込みだけのクエリにできる
+func (a *QueryAnnotations) isReadOnly(q *plugin.Query) bool {
+	return a.readonly || isReadOnlySQL(q.GetText())
+}
+
+// findParamProperty はカラム名かプロパティ名が name のパラメータのプロパティ名を返す
+func findParamProperty(q *plugin.Query, name string) string {
+	for _, p := range q.GetParams() {
+		c := p.GetColumn()
+		if c.GetName() == name || naming.toPropertyName(c) == name {
+			return naming.toPropertyName(c)
+		}
+	}
+	return ""
+}
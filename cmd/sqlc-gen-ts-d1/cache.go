@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// jsonSafeTsTypes はキャッシュに JSON で保存して取り出しても同じ値に戻る TypeScript の型
+var jsonSafeTsTypes = map[string]bool{"string": true, "number": true, "boolean": true, "null": true}
+
+// checkCacheable は @cache のクエリの結果がキャッシュから取り出しても同じ値になるかどうかを調べる
+// BLOB の ArrayBuffer や overrides で指定した型は JSON.parse で元の型に戻らないのでエラーにする
+// D1Result の meta は実行ごとに異なりキャッシュから返せないので、D1Result を返すクエリもエラーにする
+func (g *queryWriter) checkCacheable(q *plugin.Query) error {
+	if g.annotations(q).cache == nil {
+		return nil
+	}
+	if q.GetCmd() == ":many" && !g.isArrayMany(q) && g.scalarColumn(q) == nil {
+		return fmt.Errorf("%s: %s: @cache: query returning D1Result cannot be cached because of its meta; use many-return=array", q.GetFilename(), q.GetName())
+	}
+	for _, c := range q.GetColumns() {
+		columns := []*plugin.Column{c}
+		if et := c.GetEmbedTable(); et.GetName() != "" {
+			columns = g.tableMap.findTable(et).GetColumns()
+		}
+		for _, ec := range columns {
+			tsType := g.tsTypeMap.toTsType(ec)
+			for _, t := range strings.Split(tsType, "|") {
+				if !jsonSafeTsTypes[strings.TrimSpace(t)] {
+					return fmt.Errorf("%s: %s: @cache: column %s has type %s that cannot be restored from JSON", q.GetFilename(), q.GetName(), ec.GetName(), tsType)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeResultChainWithCache は @cache が指定されたクエリの場合に結果型に変換するまでの Promise のチェーンをキャッシュで包んで書き出す
+// キャッシュには変換後の結果を保存するので、キャッシュにある場合は D1 へのクエリも変換処理も実行しない
+// many-return=array の :many は D1Result の meta を保存しないように結果の配列を取り出してから保存する
+func (g *queryWriter) writeResultChainWithCache(w *bytes.Buffer, in string, q *plugin.Query, call queryCall, resultType string, needRawType bool) {
+	cache := g.annotations(q).cache
+	if cache == nil {
+		g.writeResultChain(w, in, q, call, resultType, needRawType)
+		g.writeArrayResults(w, in, q)
+		return
+	}
+
+	keys := cache.keys
+	if keys == nil {
+		for _, p := range q.GetParams() {
+			keys = append(keys, naming.toPropertyName(p.GetColumn()))
+		}
+	}
+	var keyArgs []string
+	for _, k := range keys {
+		keyArgs = append(keyArgs, propertyAccess("args", k))
+	}
+	reads, _ := queryTableDeps(g.tableMap, q)
+	var tags []string
+	for _, t := range reads {
+		tags = append(tags, "table:"+t)
+	}
+
+	fmt.Fprintf(w, "%scached(%d, %s, %s, [%s], () =>\n", in, cache.ttl, jsQuote(sqlHash(g.finalQueryText(q))), jsStringArray(tags), strings.Join(keyArgs, ", "))
+	var chain bytes.Buffer
+	g.writeResultChain(&chain, in+"  ", q, call, resultType, needRawType)
+	g.writeArrayResults(&chain, in+"  ", q)
+	w.Write(bytes.TrimSuffix(chain.Bytes(), []byte("\n")))
+	w.WriteString(")\n")
+}
+
+// writeCacheEviction は書き込みのクエリの実行後に書き込んだテーブルのキャッシュを無効にする処理を書き出す
+// 書き込みは既に成功しているので、無効にできなかった場合もクエリの結果は返して setQueryCache で指定した関数に報告する
+func (g *queryWriter) writeCacheEviction(w *bytes.Buffer, in string, q *plugin.Query) {
+	if !g.cacheEnabled {
+		return
+	}
+	_, writes := queryTableDeps(g.tableMap, q)
+	if len(writes) == 0 {
+		return
+	}
+	var tags []string
+	for _, t := range writes {
+		tags = append(tags, "table:"+t)
+	}
+	fmt.Fprintf(w, "%s  .then((r) => evictCacheTags(%s).then(() => r))\n", in, jsStringArray(tags))
+}
+
+// cacheRuntime はクエリの結果のキャッシュとアダプタ
+// タグごとのバージョンをキーに含めることで、キーを列挙できないストアでもタグでキャッシュを無効にできる
+const cacheRuntime = `/** クエリの結果を保存するストア。値は JSON の文字列で、ttl は秒数 */
+export type CacheStore = {
+  get(key: string): Promise<string | null>;
+  put(key: string, value: string, ttl?: number): Promise<void>;
+};
+let queryCache: CacheStore | undefined;
+let onCacheEvictionError = (tags: string[], error: unknown): void => {
+  console.error("failed to invalidate cache tags", tags, error);
+};
+/**
+ * @cache が指定されたクエリで使うストアを設定する。undefined の場合はキャッシュしない
+ * onEvictionError は書き込みのクエリの実行後にキャッシュを無効にできなかった場合に呼び出され、省略した場合は console.error に出力する
+ */
+export function setQueryCache(store: CacheStore | undefined, onEvictionError?: (tags: string[], error: unknown) => void): void {
+  queryCache = store;
+  if (onEvictionError) {
+    onCacheEvictionError = onEvictionError;
+  }
+}
+function cacheKey(store: CacheStore, hash: string, tags: string[], args: unknown[]): Promise<string> {
+  return Promise.all(tags.map((tag: string) => store.get("tag:" + tag)))
+    .then((versions: (string | null)[]) => "query:" + hash + ":" + versions.map((v: string | null) => v ?? "0").join(".") + ":" + JSON.stringify(args));
+}
+function cached<T>(ttl: number, hash: string, tags: string[], args: unknown[], run: () => Promise<T>): Promise<T> {
+  const store = queryCache;
+  if (!store) {
+    return run();
+  }
+  return cacheKey(store, hash, tags, args).then((key: string) =>
+    store.get(key).then((hit: string | null) => {
+      if (hit !== null) {
+        return JSON.parse(hit) as T;
+      }
+      return run().then((value: T) => store.put(key, JSON.stringify(value), ttl).then(() => value));
+    }));
+}
+/**
+ * タグが付けられたキャッシュを無効にする。タグのバージョンを更新するので古いキャッシュは使われなくなる
+ * タグは table:account の形式で、batch() で書き込みのクエリを実行した場合は invalidationTags の結果を渡して呼び出す
+ */
+export function invalidateCacheTags(tags: string[]): Promise<void> {
+  const store = queryCache;
+  if (!store) {
+    return Promise.resolve();
+  }
+  const version = Date.now().toString(36) + Math.random().toString(36).slice(2);
+  return Promise.all(tags.map((tag: string) => store.put("tag:" + tag, version))).then(() => {});
+}
+/** 書き込みのクエリの実行後にキャッシュを無効にする。書き込みは成功しているのでエラーは報告するだけにする */
+function evictCacheTags(tags: string[]): Promise<void> {
+  return invalidateCacheTags(tags).catch((e: unknown) => onCacheEvictionError(tags, e));
+}
+/** Map に保存するストア。テストや1つの isolate の中でのキャッシュに使う */
+export function mapCacheStore(map: Map<string, { value: string; expires: number }> = new Map()): CacheStore {
+  return {
+    get(key: string) {
+      const e = map.get(key);
+      if (!e || e.expires < Date.now()) {
+        map.delete(key);
+        return Promise.resolve(null);
+      }
+      return Promise.resolve(e.value);
+    },
+    put(key: string, value: string, ttl?: number) {
+      map.set(key, { value, expires: ttl === undefined ? Infinity : Date.now() + ttl * 1000 });
+      return Promise.resolve();
+    },
+  };
+}
+/** Workers KV に保存するストア。KV の expirationTtl は60秒以上なので短い ttl は60秒になる */
+export function kvCacheStore(kv: {
+  get(key: string): Promise<string | null>;
+  put(key: string, value: string, options?: { expirationTtl?: number }): Promise<void>;
+}): CacheStore {
+  return {
+    get(key: string) {
+      return kv.get(key);
+    },
+    put(key: string, value: string, ttl?: number) {
+      return kv.put(key, value, ttl === undefined ? undefined : { expirationTtl: Math.max(ttl, 60) });
+    },
+  };
+}
+/** Cache API に保存するストア。キーは origin の URL のパスにする */
+export function cacheApiStore(cache: {
+  match(request: string): Promise<Response | undefined>;
+  put(request: string, response: Response): Promise<void>;
+}, origin: string = "https://sqlc-gen-ts-d1.invalid"): CacheStore {
+  const url = (key: string) => origin + "/" + encodeURIComponent(key);
+  return {
+    get(key: string) {
+      return cache.match(url(key)).then((res: Response | undefined) => res ? res.text() : null);
+    },
+    put(key: string, value: string, ttl?: number) {
+      // タグのバージョンは ttl がないので1年保存する
+      return cache.put(url(key), new Response(value, { headers: { "Cache-Control": "max-age=" + (ttl ?? 31536000) } }));
+    },
+  };
+}
+`
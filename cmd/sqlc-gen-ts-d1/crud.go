@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// buildCrudQueries はテーブルのメタデータから Get, List, Delete のクエリを組み立てる
+// 主キーが指定されていないテーブルは主キーで検索する Get と Delete を生成しない
+// Create と Update は指定されたプロパティによってクエリが変わるので writeCrudCreate と writeCrudUpdate で別に生成する
+func buildCrudQueries(tableMap TableMap, table *plugin.Table) []*plugin.Query {
+	rel := table.GetRel()
+	modelName := naming.toModelTypeName(rel)
+	columns := crudColumns(table)
+	pk := tableMap.findPrimaryKey(rel)
+
+	var names []string
+	for _, c := range columns {
+		names = append(names, quoteIdent(c.GetName()))
+	}
+	selectList := strings.Join(names, ", ")
+	from := quoteIdent(rel.GetName())
+
+	var queries []*plugin.Query
+	if len(pk) > 0 {
+		queries = append(queries, &plugin.Query{
+			Name:    "Get" + modelName,
+			Cmd:     ":one",
+			Text:    "SELECT " + selectList + " FROM " + from + " WHERE " + crudKeyCondition(pk),
+			Columns: columns,
+			Params:  crudKeyParams(rel, pk),
+		})
+	}
+	list := "SELECT " + selectList + " FROM " + from
+	if len(pk) > 0 {
+		var orderBy []string
+		for _, c := range pk {
+			orderBy = append(orderBy, quoteIdent(c.GetName()))
+		}
+		list += " ORDER BY " + strings.Join(orderBy, ", ")
+	}
+	queries = append(queries, &plugin.Query{
+		Name:    "List" + modelName,
+		Cmd:     ":many",
+		Text:    list,
+		Columns: columns,
+	})
+	if len(pk) > 0 {
+		queries = append(queries, &plugin.Query{
+			Name:   "Delete" + modelName,
+			Cmd:    ":exec",
+			Text:   "DELETE FROM " + from + " WHERE " + crudKeyCondition(pk),
+			Params: crudKeyParams(rel, pk),
+		})
+	}
+	return queries
+}
+
+// crudCreateQuery は Create の結果型の生成に使うクエリを返す
+func crudCreateQuery(table *plugin.Table) *plugin.Query {
+	rel := table.GetRel()
+	columns := crudColumns(table)
+	var names []string
+	for _, c := range columns {
+		names = append(names, quoteIdent(c.GetName()))
+	}
+	// 挿入するカラムは実行時に決まるので /*VALUES*/ を置き換える
+	return &plugin.Query{
+		Name:            "Create" + naming.toModelTypeName(rel),
+		Cmd:             ":one",
+		Text:            "INSERT INTO " + quoteIdent(rel.GetName()) + " /*VALUES*/ RETURNING " + strings.Join(names, ", "),
+		Columns:         columns,
+		InsertIntoTable: rel,
+	}
+}
+
+// isCrudCreate は q が CRUD の Create のクエリかどうかを返す
+// INSERT ... RETURNING は必ず1行を返すので、Create の結果型は null を含まない
+func isCrudCreate(q *plugin.Query) bool {
+	return q.GetInsertIntoTable() != nil && strings.Contains(q.GetText(), "/*VALUES*/")
+}
+
+// writeCrudCreate は指定されたプロパティだけを INSERT する Create の関数を書き出す
+// 省略したカラムには DEFAULT が使われ、プロパティが1つも指定されなかった場合は DEFAULT VALUES で挿入する
+func (g *queryWriter) writeCrudCreate(w *bytes.Buffer, table *plugin.Table, q *plugin.Query) {
+	rel := table.GetRel()
+	insertModel := naming.toInsertModelTypeName(rel)
+	g.requireModels[insertModel] = true
+
+	g.writeQueryText(w, q)
+	needRawType := g.writeRowTypes(w, q)
+	retType, resultType := g.resultTypes(q, needRawType)
+	if g.scalarColumn(q) == nil {
+		retType = strings.TrimSuffix(retType, " | null")
+	}
+
+	paramDocs := [][2]string{{"args", "挿入するカラムの値。undefined のプロパティは挿入せずに DEFAULT を使う"}}
+	if g.emitHooks {
+		paramDocs = append(paramDocs, [2]string{"hooks", "省略した場合は setQueryHooks で設定したフックを使う"})
+	}
+	writeFunctionDoc(w, q, g.annotations(q), paramDocs)
+
+	g.module.declare(naming.toFunctionName(q), querySource(q))
+	fmt.Fprintf(w, "export function %s(\n", naming.toFunctionName(q))
+	g.writeParams(w, naming.toFunctionName(q), [][2]string{
+		{"d1", "D1Queryable"},
+		{"args", insertModel},
+	})
+	fmt.Fprintf(w, "): Query<%s> {\n", retType)
+	fmt.Fprintf(w, "  const params: any[] = [];\n")
+	fmt.Fprintf(w, "  const columns: string[] = [];\n")
+	for _, c := range q.GetColumns() {
+		arg := propertyAccess("args", naming.toPropertyName(c))
+		fmt.Fprintf(w, "  if (%s !== undefined) {\n", arg)
+		fmt.Fprintf(w, "    params.push(%s);\n", arg)
+		fmt.Fprintf(w, "    columns.push(%s);\n", jsQuote(quoteIdent(c.GetName())))
+		fmt.Fprintf(w, "  }\n")
+	}
+	fmt.Fprintf(w, "  const values = columns.length > 0\n")
+	fmt.Fprintf(w, "    ? \"(\" + columns.join(\", \") + \") VALUES (\" + params.map((_: unknown, i: number) => \"?\" + (i + 1)).join(\", \") + \")\"\n")
+	fmt.Fprintf(w, "    : \"DEFAULT VALUES\";\n")
+	fmt.Fprintf(w, "  const query = %s.replace(\"/*VALUES*/\", values);\n", naming.toConstQueryName(q))
+	fmt.Fprintf(w, "  const ps = d1\n")
+	fmt.Fprintf(w, "    .prepare(query)\n")
+	fmt.Fprintf(w, "    .bind(...params);\n")
+	g.writeQueryObject(w, q, queryCall{sql: "query", args: "args"}, retType, resultType, needRawType)
+	w.WriteString("}\n")
+
+	w.WriteByte('\n')
+
+	g.writeQueryMeta(w, q)
+
+	var names, placeholders []string
+	for i, c := range q.GetColumns() {
+		names = append(names, quoteIdent(c.GetName()))
+		placeholders = append(placeholders, fmt.Sprintf("?%d", i+1))
+	}
+	values := "(" + strings.Join(names, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")"
+	g.writeCrudExplain(w, q, "/*VALUES*/", values, "", nil, len(q.GetColumns()))
+}
+
+// writeCreatedRowCheck は Create の結果から null を取り除き、行が返らなかった場合はエラーを投げる処理を書き出す
+// BEFORE INSERT のトリガーで RAISE(IGNORE) された場合は RETURNING が行を返さない
+func (g *queryWriter) writeCreatedRowCheck(w *bytes.Buffer, in string, q *plugin.Query, retType string) {
+	if !isCrudCreate(q) || g.scalarColumn(q) != nil {
+		return
+	}
+	fmt.Fprintf(w, "%s  .then((row: %s | null): %s => {\n", in, retType, retType)
+	fmt.Fprintf(w, "%s    if (row === null) throw new Error(%s);\n", in, jsQuote(q.GetName()+": no row was returned by INSERT ... RETURNING"))
+	fmt.Fprintf(w, "%s    return row;\n", in)
+	fmt.Fprintf(w, "%s  })\n", in)
+}
+
+// crudUpdateQuery は Update の結果型の生成に使うクエリを返す
+// 主キーが指定されていないテーブルは nil を返す
+func crudUpdateQuery(tableMap TableMap, table *plugin.Table) *plugin.Query {
+	rel := table.GetRel()
+	pk := tableMap.findPrimaryKey(rel)
+	if len(pk) == 0 {
+		return nil
+	}
+	columns := crudColumns(table)
+	var names []string
+	for _, c := range columns {
+		names = append(names, quoteIdent(c.GetName()))
+	}
+	// 更新するカラムは実行時に決まるので /*SET*/ を置き換える
+	return &plugin.Query{
+		Name:    "Update" + naming.toModelTypeName(rel),
+		Cmd:     ":one",
+		Text:    "UPDATE " + quoteIdent(rel.GetName()) + " SET /*SET*/ WHERE " + crudKeyCondition(pk) + " RETURNING " + strings.Join(names, ", "),
+		Columns: columns,
+	}
+}
+
+// crudUpdateFallbackSQL は Update で更新するプロパティが指定されなかった場合に現在の値を返す SELECT 文を返す
+// Update のクエリの結果のカラムは全て更新するテーブルのカラムになる
+func crudUpdateFallbackSQL(tableMap TableMap, q *plugin.Query) string {
+	rel := q.GetColumns()[0].GetTable()
+	var names []string
+	for _, c := range q.GetColumns() {
+		names = append(names, quoteIdent(c.GetName()))
+	}
+	return "SELECT " + strings.Join(names, ", ") + " FROM " + quoteIdent(rel.GetName()) + " WHERE " + crudKeyCondition(tableMap.findPrimaryKey(rel))
+}
+
+// writeCrudUpdate は指定されたプロパティだけを更新する Update の関数を書き出す
+// 更新するプロパティが指定されなかった場合は UPDATE を実行せずに現在の値を返す
+func (g *queryWriter) writeCrudUpdate(w *bytes.Buffer, table *plugin.Table, q *plugin.Query) {
+	rel := table.GetRel()
+	pk := g.tableMap.findPrimaryKey(rel)
+	modelName := naming.toModelTypeName(rel)
+	updateModel := naming.toUpdateModelTypeName(rel)
+	g.requireModels[modelName] = true
+	g.requireModels[updateModel] = true
+
+	g.writeQueryText(w, q)
+	needRawType := g.writeRowTypes(w, q)
+	retType, resultType := g.resultTypes(q, needRawType)
+
+	var keys []string
+	var keyArgs []string
+	for _, c := range pk {
+		propName := naming.toPropertyName(c)
+		keys = append(keys, jsQuote(propName))
+		keyArgs = append(keyArgs, propertyAccess("key", propName))
+	}
+	var paramDocs [][2]string
+	for _, c := range pk {
+		paramDocs = append(paramDocs, [2]string{propertyAccess("key", naming.toPropertyName(c)), c.GetComment()})
+	}
+	paramDocs = append(paramDocs, [2]string{"patch", "更新するカラムの値。undefined のプロパティは更新しない"})
+	if g.emitHooks {
+		paramDocs = append(paramDocs, [2]string{"hooks", "省略した場合は setQueryHooks で設定したフックを使う"})
+	}
+	writeFunctionDoc(w, q, g.annotations(q), paramDocs)
+
+	g.module.declare(naming.toFunctionName(q), querySource(q))
+	fmt.Fprintf(w, "export function %s(\n", naming.toFunctionName(q))
+	g.writeParams(w, naming.toFunctionName(q), [][2]string{
+		{"d1", "D1Queryable"},
+		{"key", fmt.Sprintf("Pick<%s, %s>", modelName, strings.Join(keys, " | "))},
+		{"patch", updateModel},
+	})
+	fmt.Fprintf(w, "): Query<%s> {\n", retType)
+	fmt.Fprintf(w, "  const params: any[] = [%s];\n", strings.Join(keyArgs, ", "))
+	fmt.Fprintf(w, "  const sets: string[] = [];\n")
+	for _, c := range q.GetColumns() {
+		patch := propertyAccess("patch", naming.toPropertyName(c))
+		fmt.Fprintf(w, "  if (%s !== undefined) {\n", patch)
+		fmt.Fprintf(w, "    params.push(%s);\n", patch)
+		fmt.Fprintf(w, "    sets.push(%s + params.length);\n", jsQuote(quoteIdent(c.GetName())+" = ?"))
+		fmt.Fprintf(w, "  }\n")
+	}
+	fmt.Fprintf(w, "  const query = sets.length > 0\n")
+	fmt.Fprintf(w, "    ? %s.replace(\"/*SET*/\", sets.join(\", \"))\n", naming.toConstQueryName(q))
+	fmt.Fprintf(w, "    : %s;\n", jsQuote(crudUpdateFallbackSQL(g.tableMap, q)))
+	fmt.Fprintf(w, "  const ps = d1\n")
+	fmt.Fprintf(w, "    .prepare(query)\n")
+	fmt.Fprintf(w, "    .bind(...params);\n")
+	g.writeQueryObject(w, q, queryCall{sql: "query", args: "{ key, patch }"}, retType, resultType, needRawType)
+	w.WriteString("}\n")
+
+	w.WriteByte('\n')
+
+	g.writeQueryMeta(w, q)
+
+	var sets, explainArgs []string
+	for i, c := range q.GetColumns() {
+		sets = append(sets, fmt.Sprintf("%s = ?%d", quoteIdent(c.GetName()), len(pk)+i+1))
+	}
+	for _, c := range pk {
+		explainArgs = append(explainArgs, propertyAccess("args", naming.toPropertyName(c)))
+	}
+	g.writeCrudExplain(w, q, "/*SET*/", strings.Join(sets, ", "), fmt.Sprintf("Pick<%s, %s>", modelName, strings.Join(keys, " | ")), explainArgs, len(q.GetColumns()))
+}
+
+// crudColumns はテーブルのカラムをクエリの結果やパラメータとして使えるように複製する
+func crudColumns(table *plugin.Table) []*plugin.Column {
+	var columns []*plugin.Column
+	for _, c := range table.GetColumns() {
+		columns = append(columns, &plugin.Column{
+			Name:    c.GetName(),
+			NotNull: c.GetNotNull(),
+			Comment: c.GetComment(),
+			Table:   table.GetRel(),
+			Type:    c.GetType(),
+		})
+	}
+	return columns
+}
+
+// crudKeyCondition は主キーで一意に絞り込む WHERE 句の条件を返す
+// パラメータは主キーのカラムの順に ?1 から採番する
+func crudKeyCondition(pk []*plugin.Column) string {
+	var conds []string
+	for i, c := range pk {
+		conds = append(conds, fmt.Sprintf("%s = ?%d", quoteIdent(c.GetName()), i+1))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+func crudKeyParams(rel *plugin.Identifier, pk []*plugin.Column) []*plugin.Parameter {
+	var params []*plugin.Parameter
+	for i, c := range pk {
+		params = append(params, &plugin.Parameter{
+			Number: int32(i + 1),
+			Column: &plugin.Column{
+				Name:    c.GetName(),
+				NotNull: true,
+				Table:   rel,
+				Type:    c.GetType(),
+			},
+		})
+	}
+	return params
+}
+
+var simpleIdentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// quoteIdent は必要な場合のみ SQL の識別子をダブルクオートで囲む
+func quoteIdent(name string) string {
+	if simpleIdentPattern.MatchString(name) && !sqliteKeywords[strings.ToUpper(name)] {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// https://www.sqlite.org/lang_keywords.html
+var sqliteKeywords = map[string]bool{}
+
+func init() {
+	for _, k := range strings.Fields(`ABORT ACTION ADD AFTER ALL ALTER ALWAYS ANALYZE AND AS ASC ATTACH AUTOINCREMENT
+BEFORE BEGIN BETWEEN BY CASCADE CASE CAST CHECK COLLATE COLUMN COMMIT CONFLICT CONSTRAINT CREATE CROSS
+CURRENT CURRENT_DATE CURRENT_TIME CURRENT_TIMESTAMP DATABASE DEFAULT DEFERRABLE DEFERRED DELETE DESC
+DETACH DISTINCT DO DROP EACH ELSE END ESCAPE EXCEPT EXCLUDE EXCLUSIVE EXISTS EXPLAIN FAIL FILTER FIRST
+FOLLOWING FOR FOREIGN FROM FULL GENERATED GLOB GROUP GROUPS HAVING IF IGNORE IMMEDIATE IN INDEX INDEXED
+INITIALLY INNER INSERT INSTEAD INTERSECT INTO IS ISNULL JOIN KEY LAST LEFT LIKE LIMIT MATCH MATERIALIZED
+NATURAL NO NOT NOTHING NOTNULL NULL NULLS OF OFFSET ON OR ORDER OTHERS OUTER OVER PARTITION PLAN PRAGMA
+PRECEDING PRIMARY QUERY RAISE RANGE RECURSIVE REFERENCES REGEXP REINDEX RELEASE RENAME REPLACE RESTRICT
+RETURNING RIGHT ROLLBACK ROW ROWS SAVEPOINT SELECT SET TABLE TEMP TEMPORARY THEN TIES TO TRANSACTION
+TRIGGER UNBOUNDED UNION UNIQUE UPDATE USING VACUUM VALUES VIEW VIRTUAL WHEN WHERE WINDOW WITH WITHOUT`) {
+		sqliteKeywords[k] = true
+	}
+}
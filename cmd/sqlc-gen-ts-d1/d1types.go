@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// d1TypeNames は生成するコードで使う D1 の型の名前
+var d1TypeNames = []string{"D1Database", "D1PreparedStatement", "D1Result"}
+
+// D1Types は生成するコードで使う D1 の型の取得元
+type D1Types struct {
+	// source は package, subpath, global, module のいずれか
+	source string
+	// specifier は import するモジュールで、global の場合は空
+	specifier string
+	// names は D1 の型の名前から実際に使う型の名前への対応
+	names map[string]string
+}
+
+// buildD1Types はオプションから D1 の型の取得元を組み立てる
+// workers-types-v3 は types-source=global の別名として扱う
+func buildD1Types(options *Options) (*D1Types, error) {
+	source := options.TypesSource
+	if options.WorkersTypesV3 {
+		if source != "" && source != "global" {
+			return nil, fmt.Errorf("types-source: workers-types-v3 requires global: %q", source)
+		}
+		source = "global"
+	}
+	if source == "" {
+		source = "subpath"
+	}
+
+	t := &D1Types{source: source, names: map[string]string{}}
+	switch source {
+	case "package":
+		t.specifier = "@cloudflare/workers-types"
+	case "subpath":
+		t.specifier = "@cloudflare/workers-types"
+		if options.WorkersTypes != "" {
+			t.specifier += "/" + options.WorkersTypes
+		}
+	case "module":
+		if options.TypesModule == "" {
+			return nil, fmt.Errorf("types-module: required when types-source is module")
+		}
+		t.specifier = options.TypesModule
+	}
+	if options.TypesModule != "" && source != "module" {
+		return nil, fmt.Errorf("types-module: types-source must be module: %q", source)
+	}
+
+	for name, userName := range options.TypeNames {
+		if !contains(d1TypeNames, name) {
+			return nil, fmt.Errorf("type-names: must be one of %s: %q", strings.Join(d1TypeNames, ", "), name)
+		}
+		// import の場合は as で別名にするので識別子である必要がある
+		if source != "global" && !isIdentifierName(userName) {
+			return nil, fmt.Errorf("type-names: %s: invalid identifier: %q", name, userName)
+		}
+		t.names[name] = userName
+	}
+	return t, nil
+}
+
+// writeHeader は D1 の型のうち typeNames を使えるようにする import 文を header に、型の別名を w に書き出す
+// 生成するコードでは常に D1Database, D1PreparedStatement, D1Result の名前で型を参照する
+// noUnusedLocals でエラーにならないように typeNames はファイルで使う型だけにする
+func (t *D1Types) writeHeader(header, w *bytes.Buffer, module *nameScope, typeNames []string) {
+	source := "types-source=" + t.source
+	if t.specifier != "" {
+		source = t.specifier
+	}
+	for _, name := range typeNames {
+		module.declare(name, source)
+	}
+
+	if t.source == "global" {
+		// グローバルの型を別の名前で使う場合は型の別名を宣言する
+		var names []string
+		for name, userName := range t.names {
+			if userName == name || !contains(typeNames, name) {
+				continue
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if name == "D1Result" {
+				fmt.Fprintf(w, "type D1Result<T = unknown> = %s<T>;\n", t.names[name])
+			} else {
+				fmt.Fprintf(w, "type %s = %s;\n", name, t.names[name])
+			}
+		}
+		return
+	}
+
+	var specs []string
+	for _, name := range typeNames {
+		if userName, ok := t.names[name]; ok && userName != name {
+			specs = append(specs, userName+" as "+name)
+		} else {
+			specs = append(specs, name)
+		}
+	}
+	fmt.Fprintf(header, "import type { %s } from %s\n", strings.Join(specs, ", "), jsQuote(t.specifier))
+}
+
+// file は D1 の型のうち typeNames を使う name のファイルを返す
+// write はファイルの D1 の型の import より後の内容を書き出す
+func (t *D1Types) file(request *plugin.CodeGenRequest, names *nameChecker, name string, typeNames []string, write func(w *bytes.Buffer)) *plugin.File {
+	header := bytes.NewBuffer(nil)
+	appendMeta(header, request)
+	body := bytes.NewBuffer(nil)
+	imports := bytes.NewBuffer(nil)
+	t.writeHeader(imports, body, names.scope(name), typeNames)
+	if imports.Len() > 0 {
+		imports.WriteString("\n")
+	}
+	write(body)
+	header.Write(imports.Bytes())
+	header.Write(body.Bytes())
+	return &plugin.File{Name: name, Contents: header.Bytes()}
+}
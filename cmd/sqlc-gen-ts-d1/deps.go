@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// queryTableDeps はクエリが読み込むスキーマのテーブルと書き込むテーブルの名前を返す
+// SQL のテーブルの参照に加えて、結果のカラムのテーブルと INSERT 先のテーブルを使う
+func queryTableDeps(tableMap TableMap, q *plugin.Query) (reads, writes []string) {
+	// SQLite のテーブル名は大文字小文字を区別しない
+	names := map[string]string{}
+	for name := range tableMap.m {
+		names[strings.ToLower(name)] = name
+	}
+	readSet := map[string]bool{}
+	writeSet := map[string]bool{}
+
+	sqlReads, sqlWrites := sqlTableRefs(q.GetText())
+	for _, name := range sqlWrites {
+		if t, ok := names[strings.ToLower(name)]; ok {
+			writeSet[t] = true
+		}
+	}
+	if t := q.GetInsertIntoTable(); tableMap.findTable(t) != nil {
+		writeSet[t.GetName()] = true
+	}
+	for _, name := range sqlReads {
+		if t, ok := names[strings.ToLower(name)]; ok {
+			readSet[t] = true
+		}
+	}
+	for _, c := range q.GetColumns() {
+		for _, t := range []*plugin.Identifier{c.GetTable(), c.GetEmbedTable()} {
+			// RETURNING のカラムは書き込むテーブルのカラムなので読み込みに含めない
+			if tableMap.findTable(t) != nil && !writeSet[t.GetName()] {
+				readSet[t.GetName()] = true
+			}
+		}
+	}
+	return sortedKeys(readSet), sortedKeys(writeSet)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
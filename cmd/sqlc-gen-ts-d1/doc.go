@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// commentLines は SQL のコメントを JSDoc に書き出す行に分割する
+func commentLines(comment string) []string {
+	comment = strings.TrimSpace(comment)
+	if comment == "" {
+		return nil
+	}
+	return strings.Split(comment, "\n")
+}
+
+// queryDocLines はクエリの `-- name:` の上に書かれたコメントを JSDoc の説明文の行にする
+// sqlc は `--` を取り除いた行を渡すので先頭の空白を1つだけ取り除く
+// アノテーションの行は説明文に含めない
+func queryDocLines(q *plugin.Query) []string {
+	var lines []string
+	for _, c := range q.GetComments() {
+		if isAnnotation(c) {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(strings.TrimPrefix(c, " "), " \t"))
+	}
+	return lines
+}
+
+// sqlExampleLines はクエリの SQL をエディタで表示できるように JSDoc の @example のコードブロックにする
+func sqlExampleLines(q *plugin.Query) []string {
+	lines := []string{"@example", "```sql"}
+	lines = append(lines, strings.Split(q.GetText(), "\n")...)
+	return append(lines, "```")
+}
+
+// paramDocLine は @param の行を返す
+func paramDocLine(name, comment string) string {
+	// @param の説明は1行で書く必要があるので改行は空白にする
+	comment = strings.Join(commentLines(comment), " ")
+	if comment == "" {
+		return "@param " + name
+	}
+	return "@param " + name + " - " + comment
+}
+
+// writeDoc は JSDoc を書き出す
+// lines が空の場合は何も書き出さず、1行の場合は1行の JSDoc にする
+func writeDoc(w *bytes.Buffer, indent string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	for i, l := range lines {
+		// コメントの中に */ があると JSDoc が終わってしまう
+		lines[i] = strings.ReplaceAll(l, "*/", "*\\/")
+	}
+	if len(lines) == 1 {
+		fmt.Fprintf(w, "%s/** %s */\n", indent, lines[0])
+		return
+	}
+	fmt.Fprintf(w, "%s/**\n", indent)
+	for _, l := range lines {
+		if l == "" {
+			fmt.Fprintf(w, "%s *\n", indent)
+		} else {
+			fmt.Fprintf(w, "%s * %s\n", indent, l)
+		}
+	}
+	fmt.Fprintf(w, "%s */\n", indent)
+}
+
+// writeFunctionDoc はクエリの関数の JSDoc を書き出す
+// params は @param に書き出す引数のプロパティとその説明
+func writeFunctionDoc(w *bytes.Buffer, q *plugin.Query, a *QueryAnnotations, params [][2]string) {
+	lines := queryDocLines(q)
+	if len(lines) > 0 {
+		lines = append(lines, "")
+	}
+	if a.deprecated {
+		lines = append(lines, strings.TrimSpace("@deprecated "+a.deprecatedMessage))
+	}
+	for _, p := range params {
+		lines = append(lines, paramDocLine(p[0], p[1]))
+	}
+	lines = append(lines, sqlExampleLines(q)...)
+	writeDoc(w, "", lines)
+}
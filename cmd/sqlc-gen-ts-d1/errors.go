@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// writeErrors は D1 の制約違反のエラーを型付きのエラーに変換する errors.ts を書き出す
+// SQLite のエラーメッセージのテーブル名とカラム名をスキーマのテーブルとカラムの union 型に絞り込む
+func writeErrors(w *bytes.Buffer, catalog *plugin.Catalog) {
+	var tables []*plugin.Table
+	seen := map[string]bool{}
+	for _, s := range catalog.GetSchemas() {
+		for _, t := range s.GetTables() {
+			// SQLite のエラーメッセージにはスキーマ名が含まれないのでテーブル名で区別する
+			if seen[t.GetRel().GetName()] {
+				continue
+			}
+			seen[t.GetRel().GetName()] = true
+			tables = append(tables, t)
+		}
+	}
+
+	var columnUnions, columnsUnions, entries []string
+	for _, t := range tables {
+		var names []string
+		for _, c := range t.GetColumns() {
+			names = append(names, jsQuote(c.GetName()))
+		}
+		table := jsQuote(t.GetRel().GetName())
+		column := strings.Join(names, " | ")
+		columnUnions = append(columnUnions, fmt.Sprintf("  | { table: %s; column: %s }", table, column))
+		columnsUnions = append(columnsUnions, fmt.Sprintf("  | { table: %s; columns: (%s)[] }", table, column))
+		entries = append(entries, fmt.Sprintf("  [%s, [%s]],", table, strings.Join(names, ", ")))
+	}
+	if len(tables) == 0 {
+		columnUnions = []string{"  never"}
+		columnsUnions = []string{"  never"}
+	}
+
+	w.WriteString("/** スキーマのテーブルとカラム */\n")
+	fmt.Fprintf(w, "export type TableColumn =\n%s;\n", strings.Join(columnUnions, "\n"))
+	w.WriteByte('\n')
+	w.WriteString("/** スキーマのテーブルとカラム。複合 UNIQUE 制約の場合は複数のカラムになる */\n")
+	fmt.Fprintf(w, "export type TableColumns =\n%s;\n", strings.Join(columnsUnions, "\n"))
+	w.WriteByte('\n')
+	w.WriteString("const tables = new Map<string, string[]>([\n")
+	for _, e := range entries {
+		w.WriteString(e + "\n")
+	}
+	w.WriteString("]);\n")
+	w.WriteByte('\n')
+	w.WriteString(errorsRuntime)
+}
+
+// errorsRuntime はスキーマに依存しない errors.ts のエラーのクラスと解析処理
+const errorsRuntime = `/** D1 の制約違反のエラーの基底クラス */
+export abstract class ConstraintViolation extends Error {
+  /** エラーが発生したクエリの名前 */
+  query: string | undefined;
+  /** D1 が返した元のエラー */
+  cause: unknown;
+
+  constructor(message: string, cause: unknown) {
+    super(message);
+    this.cause = cause;
+  }
+}
+
+/** UNIQUE 制約と PRIMARY KEY 制約の違反 */
+export class UniqueViolation extends ConstraintViolation {
+  readonly kind = "unique";
+  /** 違反したテーブルとカラム。スキーマにないテーブルの場合は null */
+  readonly target: TableColumns | null;
+
+  constructor(message: string, cause: unknown, target: TableColumns | null) {
+    super(message, cause);
+    this.name = "UniqueViolation";
+    this.target = target;
+  }
+}
+
+/** NOT NULL 制約の違反 */
+export class NotNullViolation extends ConstraintViolation {
+  readonly kind = "not_null";
+  /** 違反したテーブルとカラム。スキーマにないテーブルの場合は null */
+  readonly target: TableColumn | null;
+
+  constructor(message: string, cause: unknown, target: TableColumn | null) {
+    super(message, cause);
+    this.name = "NotNullViolation";
+    this.target = target;
+  }
+}
+
+/** FOREIGN KEY 制約の違反。SQLite のエラーメッセージには違反したテーブルとカラムが含まれない */
+export class ForeignKeyViolation extends ConstraintViolation {
+  readonly kind = "foreign_key";
+
+  constructor(message: string, cause: unknown) {
+    super(message, cause);
+    this.name = "ForeignKeyViolation";
+  }
+}
+
+/** CHECK 制約の違反 */
+export class CheckViolation extends ConstraintViolation {
+  readonly kind = "check";
+  /** 違反した制約の名前か式 */
+  readonly constraint: string;
+
+  constructor(message: string, cause: unknown, constraint: string) {
+    super(message, cause);
+    this.name = "CheckViolation";
+    this.constraint = constraint;
+  }
+}
+
+/** kind で区別できる制約違反のエラー */
+export type ConstraintError = UniqueViolation | NotNullViolation | ForeignKeyViolation | CheckViolation;
+
+// 例: D1_ERROR: UNIQUE constraint failed: account.id: SQLITE_CONSTRAINT
+const constraintPattern = /(UNIQUE|NOT NULL|FOREIGN KEY|CHECK) constraint failed(?:: (.*?))?(?:: SQLITE_CONSTRAINT\w*)?$/m;
+
+function findColumns(detail: string): TableColumns | null {
+  let table: string | undefined;
+  const columns: string[] = [];
+  for (const s of detail.split(", ")) {
+    const i = s.indexOf(".");
+    const t = s.slice(0, i);
+    const c = s.slice(i + 1);
+    if (i < 0 || (table !== undefined && table !== t) || !tables.get(t)?.includes(c)) {
+      return null;
+    }
+    table = t;
+    columns.push(c);
+  }
+  return table === undefined ? null : { table, columns } as TableColumns;
+}
+
+/** D1 のエラーが制約違反の場合に ConstraintError に変換する。それ以外のエラーの場合は null を返す */
+export function parseD1Error(e: unknown): ConstraintError | null {
+  const message = e instanceof Error ? e.message : String(e);
+  const m = constraintPattern.exec(message);
+  if (!m) {
+    return null;
+  }
+  const detail = m[2] ?? "";
+  switch (m[1]) {
+    case "UNIQUE":
+      return new UniqueViolation(message, e, findColumns(detail));
+    case "NOT NULL": {
+      const t = findColumns(detail);
+      return new NotNullViolation(message, e, t && t.columns.length === 1 ? { table: t.table, column: t.columns[0] } as TableColumn : null);
+    }
+    case "FOREIGN KEY":
+      return new ForeignKeyViolation(message, e);
+    default:
+      return new CheckViolation(message, e, detail);
+  }
+}
+
+/** 制約違反のエラーをクエリの名前をつけた ConstraintError に変換する。それ以外のエラーはそのまま返す */
+export function wrapD1Error(e: unknown, query: string): unknown {
+  const err = parseD1Error(e);
+  if (!err) {
+    return e;
+  }
+  err.query = query;
+  err.message = query + ": " + err.message;
+  return err;
+}
+`
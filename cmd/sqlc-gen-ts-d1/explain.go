@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// explainEntry は explainAll で実行する explain の関数
+type explainEntry struct {
+	q *plugin.Query
+	// paramsType はパラメータの型で、パラメータがない場合は空文字列
+	paramsType string
+}
+
+// writeExplain はクエリと同じ SQL とパラメータで EXPLAIN QUERY PLAN を実行する関数を書き出す
+// optionalParams は writeParamsType が返す省略可能なパラメータ
+func (g *queryWriter) writeExplain(w *bytes.Buffer, q *plugin.Query, optionalParams map[string]bool) {
+	if !g.emitExplain {
+		return
+	}
+	paramsType := ""
+	if len(q.GetParams()) > 0 {
+		paramsType = naming.toParamsTypeName(q)
+	}
+	g.writeExplainFunc(w, q, paramsType, func() {
+		g.writeStatement(w, q, optionalParams, true)
+	})
+}
+
+// writeCrudExplain は実行時に SQL が書き換えられる CRUD の Create と Update で、marker を全てのカラムを指定した replacement に置き換えた SQL の EXPLAIN QUERY PLAN を実行する関数を書き出す
+// keyArgs は args から渡すパラメータで、EXPLAIN QUERY PLAN は値を使わないので残りの values 個のパラメータには null を渡す
+func (g *queryWriter) writeCrudExplain(w *bytes.Buffer, q *plugin.Query, marker, replacement, paramsType string, keyArgs []string, values int) {
+	if !g.emitExplain {
+		return
+	}
+	g.writeExplainFunc(w, q, paramsType, func() {
+		bindArgs := append([]string{}, keyArgs...)
+		for i := 0; i < values; i++ {
+			bindArgs = append(bindArgs, "null")
+		}
+		fmt.Fprintf(w, "  const ps = d1\n")
+		fmt.Fprintf(w, "    .prepare(\"EXPLAIN QUERY PLAN \" + %s.replace(%s, %s))\n", naming.toConstQueryName(q), jsQuote(marker), jsQuote(replacement))
+		fmt.Fprintf(w, "    .bind(%s);\n", strings.Join(bindArgs, ", "))
+	})
+}
+
+// writeExplainFunc は writeStatement で EXPLAIN QUERY PLAN の ps を宣言して結果を返す関数を書き出す
+// paramsType はパラメータの型で、パラメータがない場合は空文字列
+func (g *queryWriter) writeExplainFunc(w *bytes.Buffer, q *plugin.Query, paramsType string, writeStatement func()) {
+	e := explainEntry{q: q, paramsType: paramsType}
+	fmt.Fprintf(w, "/** %s の EXPLAIN QUERY PLAN の結果を返す */\n", naming.toFunctionName(q))
+	g.module.declare(naming.toExplainName(q), querySource(q))
+	fmt.Fprintf(w, "export function %s(\n", naming.toExplainName(q))
+	fmt.Fprintf(w, "  d1: D1Queryable")
+	if paramsType != "" {
+		fmt.Fprintf(w, ",\n  args: %s", paramsType)
+	}
+	fmt.Fprintf(w, "\n): Promise<QueryPlanRow[]> {\n")
+	writeStatement()
+	fmt.Fprintf(w, "  return ps.all<RawQueryPlanRow>()\n")
+	if g.resultsOptional {
+		fmt.Fprintf(w, "    .then((r: D1Result<RawQueryPlanRow>) => parseQueryPlan(r.results ?? []));\n")
+	} else {
+		fmt.Fprintf(w, "    .then((r: D1Result<RawQueryPlanRow>) => parseQueryPlan(r.results));\n")
+	}
+	w.WriteString("}\n")
+
+	w.WriteByte('\n')
+	g.explainEntries = append(g.explainEntries, e)
+}
+
+// writeExplainAll は全てのクエリの EXPLAIN QUERY PLAN を実行して大きなテーブルの SCAN を報告する explainAll を書き出す
+// tables はスキーマのテーブルの名前で、SCAN の対象がスキーマのテーブルかどうかの判定に使う
+func (g *queryWriter) writeExplainAll(w *bytes.Buffer, tables []string) {
+	g.module.declare("ExplainArgs", "sqlc-gen-ts-d1")
+	g.module.declare("explainAll", "sqlc-gen-ts-d1")
+	w.WriteString("/** explainAll に渡すクエリごとの引数。パラメータのあるクエリは引数を指定しない場合は実行しない */\n")
+	w.WriteString("export type ExplainArgs = {\n")
+	for _, e := range g.explainEntries {
+		if e.paramsType != "" {
+			fmt.Fprintf(w, "  %s?: %s;\n", propertyKey(naming.toFunctionName(e.q)), e.paramsType)
+		}
+	}
+	w.WriteString("};\n")
+	w.WriteString("/** 全てのクエリの EXPLAIN QUERY PLAN を実行し、行数が minRows 以上のテーブルの SCAN を報告する */\n")
+	w.WriteString("export function explainAll(d1: D1Queryable, sampleArgs: ExplainArgs, options: ExplainOptions = {}): Promise<QueryPlanReport[]> {\n")
+	w.WriteString("  return checkQueryPlans(d1, [\n")
+	for _, e := range g.explainEntries {
+		name := jsQuote(e.q.GetName())
+		if e.paramsType == "" {
+			fmt.Fprintf(w, "    [%s, () => %s(d1)],\n", name, naming.toExplainName(e.q))
+			continue
+		}
+		args := propertyAccess("sampleArgs", naming.toFunctionName(e.q))
+		fmt.Fprintf(w, "    [%s, %s ? () => %s(d1, %s!) : undefined],\n", name, args, naming.toExplainName(e.q), args)
+	}
+	fmt.Fprintf(w, "  ], %s, options);\n", jsStringArray(tables))
+	w.WriteString("}\n")
+
+	w.WriteByte('\n')
+}
+
+// explainRuntime は EXPLAIN QUERY PLAN の結果の型と大きなテーブルの SCAN を探す関数
+const explainRuntime = `/** EXPLAIN QUERY PLAN の結果の行 */
+export type QueryPlanRow = {
+  id: number;
+  parent: number;
+  detail: string;
+  /** SCAN, SEARCH などの操作で、それ以外の行は detail と同じになる */
+  op: string;
+  /** SCAN, SEARCH の対象のテーブル */
+  table: string | null;
+  /** SCAN, SEARCH で使うインデックス */
+  index: string | null;
+};
+type RawQueryPlanRow = {
+  id: number;
+  parent: number;
+  notused: number;
+  detail: string;
+};
+function parseQueryPlan(rows: RawQueryPlanRow[]): QueryPlanRow[] {
+  return rows.map((raw: RawQueryPlanRow) => {
+    // SQLite のバージョンによって "SCAN account" と "SCAN TABLE account" のどちらかになる
+    const m = /^(SCAN|SEARCH) (?:TABLE )?(\S+)/.exec(raw.detail);
+    const index = /USING (?:COVERING )?INDEX (\S+)/.exec(raw.detail);
+    return {
+      id: raw.id,
+      parent: raw.parent,
+      detail: raw.detail,
+      op: m ? m[1] : raw.detail,
+      table: m && m[2] !== "CONSTANT" && m[2] !== "SUBQUERY" ? m[2] : null,
+      index: index ? index[1] : null,
+    };
+  });
+}
+/** クエリの EXPLAIN QUERY PLAN の結果 */
+export type QueryPlanReport = {
+  name: string;
+  /** 引数が指定されず実行しなかった場合は null */
+  plan: QueryPlanRow[] | null;
+  /** 行数が minRows 以上のテーブルの SCAN */
+  largeScans: { table: string; rows: number; detail: string }[];
+};
+export type ExplainOptions = {
+  /** SCAN を報告するテーブルの行数の下限。デフォルトは1000 */
+  minRows?: number;
+};
+async function checkQueryPlans(d1: D1Queryable, explains: [string, (() => Promise<QueryPlanRow[]>) | undefined][], tables: string[], options: ExplainOptions): Promise<QueryPlanReport[]> {
+  const minRows = options.minRows ?? 1000;
+  // テーブルの行数はテーブルごとに1回だけ数える
+  const counts = new Map<string, Promise<number>>();
+  const count = (table: string): Promise<number> => {
+    let n = counts.get(table);
+    if (!n) {
+      n = d1.prepare("SELECT COUNT(*) AS n FROM \"" + table.replace(/"/g, "\"\"") + "\"").first<number>("n").then((v: number | null) => v ?? 0);
+      counts.set(table, n);
+    }
+    return n;
+  };
+  const reports: QueryPlanReport[] = [];
+  for (const [name, explain] of explains) {
+    if (!explain) {
+      reports.push({ name, plan: null, largeScans: [] });
+      continue;
+    }
+    const plan = await explain();
+    const largeScans: QueryPlanReport["largeScans"] = [];
+    for (const row of plan) {
+      // SQLite のテーブル名は大文字小文字を区別しない
+      const table = tables.find((t: string) => row.op === "SCAN" && t.toLowerCase() === row.table?.toLowerCase());
+      if (table === undefined) {
+        continue;
+      }
+      const rows = await count(table);
+      if (rows >= minRows) {
+        largeScans.push({ table, rows, detail: row.detail });
+      }
+    }
+    reports.push({ name, plan, largeScans });
+  }
+  return reports;
+}
+`
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// queryCall はフックに渡すクエリの実行時の情報を表す TypeScript の式
+type queryCall struct {
+	// sql は実行する SQL の変数
+	sql string
+	// args はクエリの引数の式で、引数がない場合は undefined
+	args string
+}
+
+// factoryEntry は createQueries が返すオブジェクトの関数
+type factoryEntry struct {
+	name   string
+	params [][2]string
+}
+
+// writeParams は関数の引数を1行ずつ書き出す
+// フックを使う場合は createQueries から渡すフックを最後の引数に追加する
+func (g *queryWriter) writeParams(w *bytes.Buffer, name string, params [][2]string) {
+	if g.emitHooks {
+		g.factoryEntries = append(g.factoryEntries, factoryEntry{name: name, params: params})
+		params = append(params[:len(params):len(params)], [2]string{"hooks?", "QueryHooks"})
+	}
+	for i, p := range params {
+		if i > 0 {
+			w.WriteString(",\n")
+		}
+		fmt.Fprintf(w, "  %s: %s", p[0], p[1])
+	}
+	w.WriteString("\n")
+}
+
+// writeObservedExec はフックを呼び出しながら ps を実行して resultType の結果を返すまでの Promise を書き出す
+// first() と raw() は D1Result の meta を返さないので、全てのコマンドで D1Result を返す all() か run() を使う
+func (g *queryWriter) writeObservedExec(w *bytes.Buffer, in string, q *plugin.Query, call queryCall, resultType string) {
+	observe := func(exec string) {
+		fmt.Fprintf(w, "%sobserve(hooks, %s, %s, %s, %s, () => %s)\n", in, jsQuote(q.GetName()), jsQuote(q.GetCmd()), call.sql, call.args, exec)
+	}
+	results, first := "r.results", "r.results[0]"
+	if g.resultsOptional {
+		results, first = "(r.results ?? [])", "r.results?.[0]"
+	}
+
+	if c := g.scalarColumn(q); c != nil {
+		rowType := "Record<string, " + g.tsTypeMap.toTsType(c) + ">"
+		observe("ps.all<" + rowType + ">()")
+		if q.GetCmd() == ":one" {
+			fmt.Fprintf(w, "%s  .then((r: D1Result<%s>) => %s?.[%s] ?? null)\n", in, rowType, first, jsQuote(c.GetName()))
+		} else {
+			fmt.Fprintf(w, "%s  .then((r: D1Result<%s>) => %s.map((row: %s) => row[%s]))\n", in, rowType, results, rowType, jsQuote(c.GetName()))
+		}
+		return
+	}
+
+	switch q.GetCmd() {
+	case ":one":
+		rowType := strings.TrimSuffix(resultType, " | null")
+		observe("ps.all<" + rowType + ">()")
+		fmt.Fprintf(w, "%s  .then((r: D1Result<%s>): %s => %s ?? null)\n", in, rowType, resultType, first)
+	case ":many":
+		observe("ps.all<" + resultType + ">()")
+	case ":exec":
+		observe("ps.run()")
+	}
+}
+
+// writeQueriesFactory はフックを指定して関数を呼び出せる createQueries を書き出す
+func (g *queryWriter) writeQueriesFactory(w *bytes.Buffer) {
+	g.module.declare("createQueries", "sqlc-gen-ts-d1")
+	g.module.declare("Queries", "sqlc-gen-ts-d1")
+	w.WriteString("/** 全ての関数で hooks を使う関数のオブジェクトを返す */\n")
+	w.WriteString("export function createQueries(hooks: QueryHooks) {\n")
+	w.WriteString("  return {\n")
+	for _, e := range g.factoryEntries {
+		var params, args []string
+		for _, p := range e.params {
+			params = append(params, p[0]+": "+p[1])
+			args = append(args, p[0])
+		}
+		args = append(args, "hooks")
+		fmt.Fprintf(w, "    %s: (%s) => %s(%s),\n", e.name, strings.Join(params, ", "), e.name, strings.Join(args, ", "))
+	}
+	w.WriteString("  };\n")
+	w.WriteString("}\n")
+	w.WriteString("export type Queries = ReturnType<typeof createQueries>;\n")
+
+	w.WriteByte('\n')
+}
+
+// hooksRuntime はフックの型とフックを呼び出しながらクエリを実行する関数
+const hooksRuntime = `/** フックに渡されるクエリの情報 */
+export type QueryEvent = {
+  /** クエリの名前 */
+  name: string;
+  /** :one, :many, :exec などのコマンド */
+  cmd: string;
+  /** 実行する SQL */
+  sql: string;
+  /** 関数に渡された引数。redactArgs が指定されている場合は変換後の値 */
+  args: unknown;
+};
+/** クエリの実行の前後に呼び出されるフック */
+export type QueryHooks = {
+  onQueryStart?(event: QueryEvent): void;
+  onQueryEnd?(event: QueryEvent, meta: D1Result["meta"], durationMs: number): void;
+  onQueryError?(event: QueryEvent, error: unknown, durationMs: number): void;
+  /** フックに渡す前に引数から機密情報を取り除く */
+  redactArgs?(name: string, args: unknown): unknown;
+};
+let globalHooks: QueryHooks = {};
+/** createQueries を使わずに呼び出した関数で使うフックを設定する */
+export function setQueryHooks(hooks: QueryHooks): void {
+  globalHooks = hooks;
+}
+function observe<T>(hooks: QueryHooks | undefined, name: string, cmd: string, sql: string, args: unknown, run: () => Promise<D1Result<T>>): Promise<D1Result<T>> {
+  const h = hooks ?? globalHooks;
+  const event: QueryEvent = { name, cmd, sql, args: h.redactArgs ? h.redactArgs(name, args) : args };
+  const start = Date.now();
+  h.onQueryStart?.(event);
+  return run().then((r: D1Result<T>) => {
+    h.onQueryEnd?.(event, r.meta, Date.now() - start);
+    return r;
+  }, (e: unknown) => {
+    h.onQueryError?.(event, e, Date.now() - start);
+    throw e;
+  });
+}
+`
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+// TypeScript の予約語で、識別子として使えないもの
+// https://262.ecma-international.org/#sec-keywords-and-reserved-words
+var reservedWords = map[string]bool{}
+
+// TypeScript で型の名前として使えないもの
+var reservedTypeNames = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`await break case catch class const continue debugger default delete do else enum
+export extends false finally for function if import in instanceof new null return super switch this throw true
+try typeof var void while with yield implements interface let package private protected public static
+arguments eval`) {
+		reservedWords[w] = true
+	}
+	for _, w := range strings.Fields(`any bigint boolean never number object string symbol undefined unknown`) {
+		reservedTypeNames[w] = true
+	}
+}
+
+func isIdentifierStart(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.Is(unicode.Nl, r)
+}
+
+func isIdentifierPart(r rune) bool {
+	return isIdentifierStart(r) || unicode.IsDigit(r) || unicode.In(r, unicode.Mn, unicode.Mc, unicode.Pc)
+}
+
+// isIdentifierName は name がプロパティ名として引用符なしで書けるかどうかを返す
+// プロパティ名には予約語も使えるので予約語かどうかは考慮しない
+func isIdentifierName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if r == unicode.ReplacementChar {
+			return false
+		}
+		if i == 0 && !isIdentifierStart(r) || i > 0 && !isIdentifierPart(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// toIdentifier は name を変数名や関数名として使える識別子に変換する
+// 識別子に使えない文字は _ に置き換え、数字で始まる場合は先頭に _ を、予約語の場合は末尾に _ を付ける
+func toIdentifier(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case i == 0 && unicode.IsDigit(r):
+			b.WriteByte('_')
+			b.WriteRune(r)
+		case r != unicode.ReplacementChar && (i == 0 && isIdentifierStart(r) || i > 0 && isIdentifierPart(r)):
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	s := b.String()
+	if s == "" {
+		return "_"
+	}
+	if reservedWords[s] {
+		return s + "_"
+	}
+	return s
+}
+
+// toTypeIdentifier は name を型の名前として使える識別子に変換する
+func toTypeIdentifier(name string) string {
+	s := toIdentifier(name)
+	if reservedTypeNames[s] {
+		return s + "_"
+	}
+	return s
+}
+
+// propertyKey はオブジェクトや型のプロパティのキーとして書き出す文字列を返す
+// 識別子として書けない場合は文字列リテラルにする
+func propertyKey(name string) string {
+	if isIdentifierName(name) {
+		return name
+	}
+	return jsQuote(name)
+}
+
+// propertyAccess は obj のプロパティを参照する式を返す
+func propertyAccess(obj, name string) string {
+	if isIdentifierName(name) {
+		return obj + "." + name
+	}
+	return obj + "[" + jsQuote(name) + "]"
+}
+
+// jsQuote は s を TypeScript の文字列リテラルにする
+func jsQuote(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	// 文字列のエンコードは失敗しない
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// escapeTemplateLiteral は s をテンプレートリテラルの中に書けるようにエスケープする
+func escapeTemplateLiteral(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "`", "\\`")
+	return strings.ReplaceAll(s, "${", "\\${")
+}
+
+// jsStringArray は文字列の配列のリテラルを返す
+func jsStringArray(list []string) string {
+	var quoted []string
+	for _, s := range list {
+		quoted = append(quoted, jsQuote(s))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
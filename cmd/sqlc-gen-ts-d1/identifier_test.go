@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+// identifierSeeds はテーブル名やカラム名、クエリ名として渡されうる識別子にしにくい名前
+var identifierSeeds = []string{
+	"",
+	"_",
+	"___",
+	"a__b",
+	"_leading",
+	"trailing_",
+	"1st_place",
+	"2fa",
+	"0",
+	"class",
+	"default",
+	"delete",
+	"enum",
+	"new",
+	"await",
+	"yield",
+	"arguments",
+	"string",
+	"number",
+	"undefined",
+	"order-item",
+	"user id",
+	"a.b",
+	"$ref",
+	"\"quoted\"",
+	"back`tick${x}",
+	"line\nbreak",
+	" ",
+	"名前",
+	"ユーザー_名前",
+	"émoji_😀",
+	"ǅungla",
+	"Ⅻ_roman",
+	"á",
+	"\xff\xfe",
+	"a\x00b",
+}
+
+// strictModeReservedWords は strict mode の TypeScript で変数名や関数名に使えない名前
+// reservedWords の写しにならないように、TypeScript のコンパイラの scanner の分類から作る
+// https://github.com/microsoft/TypeScript/blob/main/src/compiler/types.ts (FirstReservedWord から LastFutureReservedWord)
+var strictModeReservedWords = map[string]bool{}
+
+func init() {
+	for _, group := range [][]string{
+		// SyntaxKind.FirstReservedWord から LastReservedWord まで
+		{"break", "case", "catch", "class", "const", "continue", "debugger", "default", "delete", "do", "else", "enum",
+			"export", "extends", "false", "finally", "for", "function", "if", "import", "in", "instanceof", "new",
+			"null", "return", "super", "switch", "this", "throw", "true", "try", "typeof", "var", "void", "while", "with"},
+		// SyntaxKind.FirstFutureReservedWord から LastFutureReservedWord までで、strict mode では予約語になる
+		{"implements", "interface", "let", "package", "private", "protected", "public", "static", "yield"},
+		// ES モジュールでは予約語になる
+		{"await"},
+		// strict mode では束縛する名前に使えない
+		{"arguments", "eval"},
+	} {
+		for _, w := range group {
+			strictModeReservedWords[w] = true
+		}
+	}
+}
+
+func TestReservedWords(t *testing.T) {
+	for w := range strictModeReservedWords {
+		if !reservedWords[w] {
+			t.Errorf("reservedWords does not contain %q", w)
+		}
+	}
+	for w := range reservedWords {
+		if !strictModeReservedWords[w] {
+			t.Errorf("reservedWords contains %q that is not reserved in strict mode", w)
+		}
+	}
+}
+
+// validIdentifier は s が TypeScript の識別子として書けるかどうかを返す
+// https://262.ecma-international.org/#sec-names-and-keywords
+func validIdentifier(s string) bool {
+	if s == "" || !utf8.ValidString(s) {
+		return false
+	}
+	for i, r := range s {
+		start := r == '$' || r == '_' || unicode.In(r, unicode.Lu, unicode.Ll, unicode.Lt, unicode.Lm, unicode.Lo, unicode.Nl)
+		part := start || unicode.In(r, unicode.Mn, unicode.Mc, unicode.Nd, unicode.Pc)
+		if i == 0 && !start || !part {
+			return false
+		}
+	}
+	return !strictModeReservedWords[s]
+}
+
+// checkPropertyKey は key が name を表すプロパティのキーとして正しく書かれているかを調べる
+func checkPropertyKey(t *testing.T, name, key string) {
+	t.Helper()
+	if !strings.HasPrefix(key, `"`) {
+		// プロパティ名には予約語も使えるので識別子の文字だけを調べる
+		if key != name || !validIdentifier(key) && !strictModeReservedWords[key] {
+			t.Errorf("propertyKey(%q) = %s, want a valid identifier name or a quoted key", name, key)
+		}
+		return
+	}
+	var decoded string
+	if err := json.Unmarshal([]byte(key), &decoded); err != nil {
+		t.Fatalf("propertyKey(%q) = %s is not a string literal: %v", name, key, err)
+	}
+	// 不正な UTF-8 は U+FFFD に置き換えられる
+	if utf8.ValidString(name) && decoded != name {
+		t.Errorf("propertyKey(%q) = %s, decoded to %q", name, key, decoded)
+	}
+	if strings.ContainsAny(key, "\n\r\u2028\u2029") {
+		t.Errorf("propertyKey(%q) = %s contains a line terminator", name, key)
+	}
+}
+
+func FuzzToIdentifier(f *testing.F) {
+	for _, s := range identifierSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		if s := toIdentifier(name); !validIdentifier(s) {
+			t.Errorf("toIdentifier(%q) = %q, want a valid identifier", name, s)
+		}
+		if s := toTypeIdentifier(name); !validIdentifier(s) || reservedTypeNames[s] {
+			t.Errorf("toTypeIdentifier(%q) = %q, want a valid type name", name, s)
+		}
+		// クエリ名から関数名と型の名前を作る経路
+		if s := toIdentifier(toLowerCamel(name)); !validIdentifier(s) {
+			t.Errorf("toIdentifier(toLowerCamel(%q)) = %q, want a valid identifier", name, s)
+		}
+		if s := toTypeIdentifier(toUpperCamel(name) + "Row"); !validIdentifier(s) {
+			t.Errorf("toTypeIdentifier(toUpperCamel(%q)) = %q, want a valid type name", name, s)
+		}
+	})
+}
+
+func FuzzPropertyKey(f *testing.F) {
+	for _, s := range identifierSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		checkPropertyKey(t, name, propertyKey(name))
+		// camel の property-naming でカラム名からプロパティ名を作る経路
+		camel := toLowerCamel(name)
+		if camel != "" {
+			checkPropertyKey(t, camel, propertyKey(camel))
+		}
+		access := propertyAccess("row", name)
+		if !strings.HasPrefix(access, "row.") && !strings.HasPrefix(access, "row[") {
+			t.Errorf("propertyAccess(row, %q) = %s", name, access)
+		}
+	})
+}
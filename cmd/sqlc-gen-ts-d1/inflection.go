@@ -0,0 +1,95 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Inflection はテーブル名からモデルの型名を作る際に単数形に変換する
+type Inflection struct {
+	// exclude は単数形に変換しないテーブル名
+	exclude map[string]bool
+	// overrides はテーブル名から単数形への対応で、変換規則より優先される
+	overrides map[string]string
+}
+
+// singular はテーブル名を単数形に変換する
+// snake_case のテーブル名は最後の単語だけを単数形にする (例: user_accounts => user_account)
+func (in *Inflection) singular(table string) string {
+	if in.exclude[table] {
+		return table
+	}
+	if s, ok := in.overrides[table]; ok {
+		return s
+	}
+	i := strings.LastIndex(table, "_")
+	return table[:i+1] + singularize(table[i+1:])
+}
+
+// 単数形と複数形が同じ、または不可算の単語
+var uncountableWords = map[string]bool{
+	"data":        true,
+	"equipment":   true,
+	"fish":        true,
+	"information": true,
+	"metadata":    true,
+	"money":       true,
+	"news":        true,
+	"series":      true,
+	"sheep":       true,
+	"species":     true,
+}
+
+// 不規則に変化する単語の複数形から単数形への対応
+var irregularWords = map[string]string{
+	"children": "child",
+	"feet":     "foot",
+	"geese":    "goose",
+	"men":      "man",
+	"mice":     "mouse",
+	"movies":   "movie",
+	"oxen":     "ox",
+	"people":   "person",
+	"teeth":    "tooth",
+	"women":    "woman",
+}
+
+// 上から順に最初に一致した規則で単数形に変換する
+var singularRules = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)(matr)ices$`), "${1}ix"},
+	{regexp.MustCompile(`(?i)(vert|ind)ices$`), "${1}ex"},
+	{regexp.MustCompile(`(?i)(alias|status|bus)es$`), "${1}"},
+	{regexp.MustCompile(`(?i)([^aeiouy]|qu)ies$`), "${1}y"},
+	// -che で終わる単語は次の -ches の規則より先に変換する
+	{regexp.MustCompile(`(?i)(cach|nich|avalanch|headach|moustach|mustach|clich)es$`), "${1}e"},
+	{regexp.MustCompile(`(?i)(x|ch|ss|sh|zz)es$`), "${1}"},
+	{regexp.MustCompile(`(?i)(kni|wi|li)ves$`), "${1}fe"},
+	{regexp.MustCompile(`(?i)(wol|hal|shel|sel|cal|lea|loa|thie|el)ves$`), "${1}f"},
+	{regexp.MustCompile(`(?i)(buffal|her|potat|tomat|ech|vet)oes$`), "${1}o"},
+	{regexp.MustCompile(`(?i)(ss|us|is)$`), "${1}"},
+	{regexp.MustCompile(`(?i)s$`), ""},
+}
+
+// singularize は英単語を単数形に変換する
+func singularize(word string) string {
+	lower := strings.ToLower(word)
+	if uncountableWords[lower] {
+		return word
+	}
+	if s, ok := irregularWords[lower]; ok {
+		// 先頭が大文字の場合は大文字を維持する
+		if word[:1] != lower[:1] {
+			return strings.ToUpper(s[:1]) + s[1:]
+		}
+		return s
+	}
+	for _, r := range singularRules {
+		if r.pattern.MatchString(word) {
+			return r.pattern.ReplaceAllString(word, r.replacement)
+		}
+	}
+	return word
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestSingularize(t *testing.T) {
+	for _, tt := range []struct {
+		plural, want string
+	}{
+		{"accounts", "account"},
+		{"categories", "category"},
+		{"boxes", "box"},
+		{"churches", "church"},
+		{"addresses", "address"},
+		{"caches", "cache"},
+		{"niches", "niche"},
+		{"Caches", "Cache"},
+		{"headaches", "headache"},
+		{"statuses", "status"},
+		{"matrices", "matrix"},
+		{"indices", "index"},
+		{"wolves", "wolf"},
+		{"knives", "knife"},
+		{"heroes", "hero"},
+		{"people", "person"},
+		{"People", "Person"},
+		{"news", "news"},
+		{"status", "status"},
+		{"account", "account"},
+	} {
+		if got := singularize(tt.plural); got != tt.want {
+			t.Errorf("singularize(%q) = %q, want %q", tt.plural, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// loaderKey は Loader を生成できるクエリの場合に sqlc.slice のパラメータと結果の行からキーを取り出す式を返す
+// :many で唯一のパラメータが sqlc.slice であり、そのカラムが結果に含まれるクエリが対象になる
+// 生成できない場合は nil を返す
+func loaderKey(q *plugin.Query, nullableEmbeds map[string]bool) (*plugin.Column, string) {
+	if q.GetCmd() != ":many" || len(q.GetParams()) != 1 {
+		return nil, ""
+	}
+	param := q.GetParams()[0].GetColumn()
+	if !param.GetIsSqlcSlice() || param.GetTable().GetName() == "" {
+		return nil, ""
+	}
+	// sqlc.slice(ids) のパラメータは名前が ids になり、比較しているカラムの名前は OriginalName に入る
+	name := param.GetOriginalName()
+	if name == "" {
+		name = param.GetName()
+	}
+	key := &plugin.Column{Name: name}
+	for _, c := range q.GetColumns() {
+		if c.GetEmbedTable().GetName() == "" && c.GetTable().GetName() == param.GetTable().GetName() && c.GetName() == name {
+			return param, propertyAccess("row", naming.toPropertyName(c))
+		}
+	}
+	for _, c := range q.GetColumns() {
+		// null になりうる sqlc.embed からはキーを取り出せない
+		if c.GetEmbedTable().GetName() == param.GetTable().GetName() && !nullableEmbeds[c.GetName()] {
+			return param, propertyAccess(propertyAccess("row", naming.toPropertyName(c)), naming.toPropertyName(key))
+		}
+	}
+	return nil, ""
+}
+
+// writeLoader はクエリを sqlc.slice の要素ごとに呼び出せる Loader を返す関数を書き出す
+// emit-loaders が指定された場合は生成できる全てのクエリ、そうでない場合は @loader が指定されたクエリが対象になる
+func (g *queryWriter) writeLoader(w *bytes.Buffer, q *plugin.Query) {
+	a := g.annotations(q)
+	if !g.emitLoaders && !a.loader {
+		return
+	}
+	// 結果を値で返す場合は行からキーを取り出せない
+	if g.scalarColumn(q) != nil {
+		return
+	}
+	param, keyExpr := loaderKey(q, a.nullableEmbeds)
+	if param == nil {
+		return
+	}
+	g.requireLoader = true
+
+	paramName := naming.toPropertyName(param)
+	keyType := naming.toParamsTypeName(q) + "[" + jsQuote(paramName) + "][number]"
+	rowType := naming.toQueryRowTypeName(q)
+	retType := "D1Result<" + rowType + ">"
+	if g.isArrayMany(q) {
+		retType = rowType + "[]"
+	}
+	args := "{ " + propertyKey(paramName) + ": keys }"
+	if g.emitHooks {
+		args += ", hooks"
+	}
+
+	fmt.Fprintf(w, "/**\n")
+	fmt.Fprintf(w, " * %s を %s の要素ごとに呼び出せる Loader を返す\n", naming.toFunctionName(q), paramName)
+	fmt.Fprintf(w, " * 同じタイミングで呼び出された load はまとめて実行され、結果は %s で振り分けられる\n", keyExpr)
+	fmt.Fprintf(w, " */\n")
+	g.module.declare(naming.toLoaderName(q), querySource(q))
+	fmt.Fprintf(w, "export function %s(\n", naming.toLoaderName(q))
+	g.writeParams(w, naming.toLoaderName(q), [][2]string{{"d1", "D1Queryable"}})
+	fmt.Fprintf(w, "): Loader<%s, %s> {\n", keyType, rowType)
+	fmt.Fprintf(w, "  return createLoader(\n")
+	fmt.Fprintf(w, "    (keys: %s[]) => new Promise<%s>((resolve, reject) => %s(d1, %s).then(resolve, reject))", keyType, retType, naming.toFunctionName(q), args)
+	if g.isArrayMany(q) {
+		w.WriteString(",\n")
+	} else if g.resultsOptional {
+		fmt.Fprintf(w, "\n      .then((r: %s) => r.results ?? []),\n", retType)
+	} else {
+		fmt.Fprintf(w, "\n      .then((r: %s) => r.results),\n", retType)
+	}
+	fmt.Fprintf(w, "    (row: %s) => %s,\n", rowType, keyExpr)
+	fmt.Fprintf(w, "  );\n")
+	w.WriteString("}\n")
+
+	w.WriteByte('\n')
+}
+
+// loaderRuntime は Loader の型と Loader を作る関数
+const loaderRuntime = `/** キーごとの結果を返す。同じタイミングで呼び出された load はまとめて1つのクエリで実行される */
+export type Loader<K, V> = {
+  /** キーの最初の行を返す。行がない場合は null を返す */
+  load(key: K): Promise<V | null>;
+  /** キーの全ての行を返す */
+  loadAll(key: K): Promise<V[]>;
+};
+// D1 は1つのクエリにバインドできるパラメータが100個まで
+const maxLoaderKeys = 100;
+function createLoader<K, V>(run: (keys: K[]) => Promise<V[]>, keyOf: (row: V) => K): Loader<K, V> {
+  type Waiter = { resolve: (rows: V[]) => void; reject: (reason?: any) => void };
+  let pending: Map<K, Waiter[]> | undefined;
+  const dispatch = (batch: Map<K, Waiter[]>) => {
+    const keys = [...batch.keys()];
+    for (let i = 0; i < keys.length; i += maxLoaderKeys) {
+      const chunk = keys.slice(i, i + maxLoaderKeys);
+      run(chunk).then((rows: V[]) => {
+        const grouped = new Map<K, V[]>();
+        for (const row of rows) {
+          const k = keyOf(row);
+          const list = grouped.get(k);
+          if (list) {
+            list.push(row);
+          } else {
+            grouped.set(k, [row]);
+          }
+        }
+        for (const k of chunk) {
+          batch.get(k)!.forEach((w: Waiter) => w.resolve(grouped.get(k) ?? []));
+        }
+      }, (e: unknown) => {
+        for (const k of chunk) {
+          batch.get(k)!.forEach((w: Waiter) => w.reject(e));
+        }
+      });
+    }
+  };
+  const loadAll = (key: K): Promise<V[]> => new Promise((resolve, reject) => {
+    if (!pending) {
+      const batch = new Map<K, Waiter[]>();
+      pending = batch;
+      setTimeout(() => {
+        pending = undefined;
+        dispatch(batch);
+      }, 0);
+    }
+    // 同じキーは1つにまとめてクエリに渡す
+    const waiters = pending.get(key);
+    if (waiters) {
+      waiters.push({ resolve, reject });
+    } else {
+      pending.set(key, [{ resolve, reject }]);
+    }
+  });
+  return {
+    load: (key: K) => loadAll(key).then((rows: V[]) => rows[0] ?? null),
+    loadAll,
+  };
+}
+`
@@ -4,12 +4,14 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sort"
-	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
 )
@@ -20,31 +22,149 @@ func handler(request *plugin.CodeGenRequest) (*plugin.CodeGenResponse, error) {
 	if err != nil {
 		return nil, fmt.Errorf("parse option: %w", err)
 	}
-	workersTypesVersion := "2022-11-30"
-	if v, ok := options["workers-types"]; ok {
-		workersTypesVersion = v
+	types, err := buildD1Types(options)
+	if err != nil {
+		return nil, err
+	}
+	// workers-types-v3 は D1Result の results が undefined になりうる
+	resultsOptional := bool(options.ResultsOptional || options.WorkersTypesV3)
+	manyReturnArray := options.ManyReturn == "array"
+	emitQueryMeta := bool(options.EmitQueryMeta)
+	emitErrors := bool(options.EmitErrors)
+	emitHooks := bool(options.EmitHooks)
+	emitManifest := bool(options.EmitManifest)
+	emitLoaders := bool(options.EmitLoaders)
+	emitExplain := bool(options.EmitExplain)
+	emitVerify := bool(options.EmitVerify)
+	emitSchema := bool(options.EmitSchema)
+	emitCatalogSnapshot := bool(options.EmitCatalogSnapshot)
+
+	naming = Naming{
+		rename:         request.GetSettings().GetRename(),
+		propertyNaming: options.PropertyNaming,
 	}
-	workersTypesV3 := false
-	if v, ok := options["workers-types-v3"]; ok {
-		workersTypesV3 = v == "1"
+	if options.SingularizeTableNames {
+		inflection := &Inflection{
+			exclude:   map[string]bool{},
+			overrides: map[string]string{},
+		}
+		for _, name := range options.InflectionExcludeTableNames {
+			inflection.exclude[name] = true
+		}
+		for table, singular := range options.InflectionOverrides {
+			inflection.overrides[table] = singular
+		}
+		naming.inflection = inflection
 	}
 
 	tsTypeMap := buildTsTypeMap(request.GetSettings())
+	tableMap := buildTableMap(request.GetCatalog())
+	if err := tableMap.setPrimaryKeys(options.PrimaryKeys); err != nil {
+		return nil, fmt.Errorf("primary-keys: %w", err)
+	}
+	// 結果を値で返すクエリ
+	scalarAll := bool(options.Scalar)
+	scalarQueries := map[string]bool{}
+	if len(options.ScalarQueries) > 0 {
+		queries := map[string]*plugin.Query{}
+		for _, q := range request.GetQueries() {
+			queries[q.GetName()] = q
+		}
+		for _, name := range options.ScalarQueries {
+			q := queries[name]
+			if q == nil {
+				return nil, fmt.Errorf("scalar-queries: query not found: %s", name)
+			}
+			if !isScalarQuery(q) {
+				return nil, fmt.Errorf("scalar-queries: %s must be :one or :many and return exactly one non-embed column", name)
+			}
+			scalarQueries[name] = true
+		}
+	}
+	// CRUD のクエリを生成するテーブル
+	crudAll := bool(options.Crud)
+	crudTables := map[string]bool{}
+	for _, name := range options.CrudTables {
+		if tableMap.findTable(&plugin.Identifier{Name: name}) == nil {
+			return nil, fmt.Errorf("crud-tables: table not found: %s", name)
+		}
+		crudTables[name] = true
+	}
+
+	// クエリのアノテーションの誤りはまとめて報告する
+	annotations := map[string]*QueryAnnotations{}
+	var annotationErrs []error
+	// @cache のクエリがある場合だけキャッシュの処理を書き出す
+	cacheEnabled := false
+	for _, q := range request.GetQueries() {
+		a, err := parseAnnotations(q)
+		if err != nil {
+			annotationErrs = append(annotationErrs, err)
+			continue
+		}
+		annotations[q.GetName()] = a
+		if a.cache != nil {
+			cacheEnabled = true
+		}
+	}
+	if len(annotationErrs) > 0 {
+		return nil, fmt.Errorf("invalid annotations:\n%w", errors.Join(annotationErrs...))
+	}
+
+	// 生成するコードの名前の衝突はファイルを書き出した後にまとめて報告する
+	names := &nameChecker{}
+
 	var files []*plugin.File
 	{
 		// sqlc.embed の際にスキーマの型が必要になるので models.ts として書き出す
 		models := bytes.NewBuffer(nil)
 		appendMeta(models, request)
+		module := names.scope("models.ts")
 		for _, s := range request.GetCatalog().GetSchemas() {
 			for _, t := range s.GetTables() {
 				modelName := naming.toModelTypeName(t.GetRel())
+				module.declare(modelName, tableSource(t.GetRel()))
+				module.declare(naming.toInsertModelTypeName(t.GetRel()), tableSource(t.GetRel()))
+				module.declare(naming.toUpdateModelTypeName(t.GetRel()), tableSource(t.GetRel()))
+				props := names.scope("models.ts: " + modelName)
+				for _, c := range t.GetColumns() {
+					props.declare(naming.toPropertyName(c), columnSource(c))
+				}
+
+				writeDoc(models, "", commentLines(t.GetComment()))
 				fmt.Fprintf(models, "export type %s = {\n", modelName)
 				for _, c := range t.GetColumns() {
-					colName := naming.toPropertyName(c)
+					writeDoc(models, "  ", commentLines(c.GetComment()))
+					colName := propertyKey(naming.toPropertyName(c))
 					tsType := tsTypeMap.toTsType(c)
 					fmt.Fprintf(models, "  %s: %s;\n", colName, tsType)
 				}
 				fmt.Fprintf(models, "};\n\n")
+
+				// INSERT 用の型は省略可能なカラム(nullable と rowid のエイリアス)を optional にする
+				writeDoc(models, "", commentLines(t.GetComment()))
+				fmt.Fprintf(models, "export type %s = {\n", naming.toInsertModelTypeName(t.GetRel()))
+				for _, c := range t.GetColumns() {
+					writeDoc(models, "  ", commentLines(c.GetComment()))
+					colName := propertyKey(naming.toPropertyName(c))
+					if tableMap.isInsertOptional(t.GetRel(), c) {
+						colName += "?"
+					}
+					tsType := tsTypeMap.toTsType(c)
+					fmt.Fprintf(models, "  %s: %s;\n", colName, tsType)
+				}
+				fmt.Fprintf(models, "};\n\n")
+
+				// UPDATE 用の型は変更するカラムだけを指定できるように全て optional にする
+				writeDoc(models, "", commentLines(t.GetComment()))
+				fmt.Fprintf(models, "export type %s = {\n", naming.toUpdateModelTypeName(t.GetRel()))
+				for _, c := range t.GetColumns() {
+					writeDoc(models, "  ", commentLines(c.GetComment()))
+					colName := propertyKey(naming.toPropertyName(c))
+					tsType := tsTypeMap.toTsType(c)
+					fmt.Fprintf(models, "  %s?: %s;\n", colName, tsType)
+				}
+				fmt.Fprintf(models, "};\n\n")
 			}
 		}
 		files = append(files, &plugin.File{Name: "models.ts", Contents: models.Bytes()})
@@ -53,249 +173,170 @@ func handler(request *plugin.CodeGenRequest) (*plugin.CodeGenResponse, error) {
 	{
 		querier := bytes.NewBuffer(nil)
 
-		tableMap := buildTableMap(request.GetCatalog())
-
-		workersTypesPackage := "@cloudflare/workers-types"
-		if workersTypesVersion != "" {
-			workersTypesPackage += "/" + workersTypesVersion
-		}
+		module := names.scope("querier.ts")
 
 		header := bytes.NewBuffer(nil)
 		appendMeta(header, request)
-		if !workersTypesV3 {
-			header.WriteString("import { D1Database, D1PreparedStatement, D1Result } from \"" + workersTypesPackage + "\"\n")
-		}
+		types.writeHeader(header, querier, module, d1TypeNames)
 
+		module.declare("Query", "sqlc-gen-ts-d1")
+		module.declare("D1Queryable", "sqlc-gen-ts-d1")
+
+		// withSession() が返す D1DatabaseSession は D1Database ではないので prepare だけを要求する
+		querier.WriteString("/** D1Database と D1Database.withSession() が返す D1DatabaseSession のどちらも受け付ける */\n")
+		querier.WriteString("export type D1Queryable = Pick<D1Database, \"prepare\">;\n")
 		querier.WriteString("type Query<T> = {\n")
 		querier.WriteString("  then(onFulfilled?: (value: T) => void, onRejected?: (reason?: any) => void): void;\n")
 		querier.WriteString("  batch(): D1PreparedStatement;\n")
 		querier.WriteString("}\n")
-
-		requireModels := map[string]bool{}
-		requireExpandedParams := false
-
-		for _, q := range request.GetQueries() {
-			queryText := q.GetText()
-			// sqlc.embed はカラムを x.a, x.b, x.c のような形で展開する
-			// 複数の sqlc.embed が展開された結果、重複した名前のカラムの情報が得られない処理系がある
-			// そのため x.a AS x_a, x.b AS x_b, x.c AS x_c のようにクエリを書き換えることで問題を回避する
-			// カラムを一つずつ書き換えた場合は前方一致や後方一致を考慮する必要があるのでまとめて書き換えを行う
-			for _, c := range q.GetColumns() {
-				et := c.GetEmbedTable()
-				if et.GetName() == "" {
-					continue
-				}
-				var news, olds []string
-				for _, ec := range tableMap.findTable(et).GetColumns() {
-					from := et.GetName() + "." + ec.GetName()
-					to := from + " AS " + naming.toEmbedColumnName(et, ec)
-					olds = append(olds, from)
-					news = append(news, to)
-				}
-				queryText = strings.Replace(queryText, strings.Join(olds, ", "), strings.Join(news, ", "), 1)
+		if manyReturnArray {
+			module.declare("ManyQuery", "sqlc-gen-ts-d1")
+			querier.WriteString("type ManyQuery<T> = Query<T[]> & {\n")
+			querier.WriteString("  withMeta(): Query<D1Result<T>>;\n")
+			querier.WriteString("}\n")
+		}
+		if emitHooks {
+			for _, name := range []string{"QueryEvent", "QueryHooks", "globalHooks", "setQueryHooks", "observe"} {
+				module.declare(name, "sqlc-gen-ts-d1")
 			}
+			querier.WriteString(hooksRuntime)
+		}
+		if cacheEnabled {
+			for _, name := range []string{"CacheStore", "queryCache", "onCacheEvictionError", "setQueryCache", "cacheKey", "cached", "invalidateCacheTags", "evictCacheTags", "mapCacheStore", "kvCacheStore", "cacheApiStore"} {
+				module.declare(name, "sqlc-gen-ts-d1")
+			}
+			querier.WriteString(cacheRuntime)
+		}
+		if emitQueryMeta {
+			module.declare("QueryMeta", "sqlc-gen-ts-d1")
+			module.declare("sessionConstraint", "sqlc-gen-ts-d1")
+			module.declare("cacheTags", "sqlc-gen-ts-d1")
+			module.declare("invalidationTags", "sqlc-gen-ts-d1")
+			querier.WriteString(`/** クエリの情報。readonly はクエリがデータを変更しないかどうか、reads と writes は読み込むテーブルと書き込むテーブル */
+export type QueryMeta = {
+  name: string;
+  cmd: string;
+  readonly: boolean;
+  reads: readonly string[];
+  writes: readonly string[];
+};
+/** D1Database.withSession() に渡す制約。データを変更するクエリはプライマリで実行する */
+export function sessionConstraint(meta: QueryMeta): "first-primary" | "first-unconstrained" {
+  return meta.readonly ? "first-unconstrained" : "first-primary";
+}
+/** クエリの結果をキャッシュするときに付けるタグ。読み込むテーブルごとのタグになる */
+export function cacheTags(meta: QueryMeta): string[] {
+  return meta.reads.map((table: string) => "table:" + table);
+}
+/** 書き込みのクエリを実行した後に無効にするキャッシュのタグ */
+export function invalidationTags(meta: QueryMeta): string[] {
+  return meta.writes.map((table: string) => "table:" + table);
+}
+`)
+		}
 
-			query := "-- name: " + q.GetName() + " " + q.GetCmd() + "\n" + queryText
-			fmt.Fprintf(querier, "const %s = `%s`;\n", naming.toConstQueryName(q), query)
+		g := &queryWriter{
+			tableMap:        tableMap,
+			tsTypeMap:       tsTypeMap,
+			resultsOptional: resultsOptional,
+			requireModels:   map[string]bool{},
 
-			querier.WriteByte('\n')
+			requireModelMappings: map[string]bool{},
 
-			// パラメータが0個の場合は引数から削除するので型を生成しない
-			if len(q.GetParams()) > 0 {
-				fmt.Fprintf(querier, "export type %s = {\n", naming.toParamsTypeName(q))
-				for _, p := range q.GetParams() {
-					c := p.GetColumn()
-					paramName := naming.toPropertyName(c)
-					tsType := tsTypeMap.toTsType(c)
-					// パラメータは sqlc.narg を使った場合のみ nullable
-					if c.GetNotNull() {
-						// パラメータに対応するカラムがわかっていて、スキーマ上で nullable であればパラメータを nullable とする
-						if tc := tableMap.findColumn(c); tc != nil && !tc.GetNotNull() {
-							tsType += " | null"
-						}
-					}
-					fmt.Fprintf(querier, "  %s: %s;\n", paramName, tsType)
-				}
-				querier.WriteString("};\n")
+			scalarAll:     scalarAll,
+			scalarQueries: scalarQueries,
 
-				querier.WriteByte('\n')
-			}
+			manyReturnArray: manyReturnArray,
 
-			needRawType := false
-			// :exec はレスポンスが返ってこないので型を生成しない
-			if q.GetCmd() != ":exec" {
-				fmt.Fprintf(querier, "export type %s = {\n", naming.toQueryRowTypeName(q))
-				for _, c := range q.GetColumns() {
-					colName := c.GetName()
-					propName := naming.toPropertyName(c)
-
-					// カラム名(snake)とプロパティ名(camel)が異なる場合
-					// 生成コードの内部で変換する必要があるのでクエリの内部結果型が必要になる
-					if colName != propName {
-						needRawType = true
-					}
+			emitQueryMeta: emitQueryMeta,
+			emitErrors:    emitErrors,
+			emitHooks:     emitHooks,
+			emitManifest:  emitManifest,
+			cacheEnabled:  cacheEnabled,
+			emitLoaders:   emitLoaders,
+			emitExplain:   emitExplain,
 
-					tsType := ""
-
-					// sqlc.embed が使われている場合
-					// 生成コードの内部で変換する必要があるのでクエリの内部結果型が必要になる
-					if et := c.GetEmbedTable(); et.GetName() != "" {
-						needRawType = true
-						tsType = naming.toModelTypeName(et)
-						// models.ts から import が必要になる
-						requireModels[tsType] = true
-					} else {
-						tsType = tsTypeMap.toTsType(c)
-					}
-					fmt.Fprintf(querier, "  %s: %s;\n", propName, tsType)
-				}
-				querier.WriteString("};\n")
+			queryAnnotations: annotations,
 
-				querier.WriteByte('\n')
+			names:  names,
+			module: module,
+		}
+		// @cache はオプションによって決まる結果の型を調べるので queryWriter を作ってから調べる
+		for _, q := range request.GetQueries() {
+			if err := g.checkCacheable(q); err != nil {
+				annotationErrs = append(annotationErrs, err)
 			}
+		}
+		if len(annotationErrs) > 0 {
+			return nil, fmt.Errorf("invalid annotations:\n%w", errors.Join(annotationErrs...))
+		}
+		for _, q := range request.GetQueries() {
+			g.writeQuery(querier, q)
+		}
 
-			// 內部結果型が必要な場合のみ生成する
-			if needRawType {
-				fmt.Fprintf(querier, "type %s = {\n", naming.toRawQueryRowTypeName(q))
-				for _, c := range q.GetColumns() {
-					// sqlc.embed の場合、スキーマからカラムの情報を取得し展開する
-					if et := c.GetEmbedTable(); et.GetName() != "" {
-						for _, ec := range tableMap.findTable(et).GetColumns() {
-							colName := naming.toEmbedColumnName(et, ec)
-							tsType := tsTypeMap.toTsType(ec)
-							fmt.Fprintf(querier, "  %s: %s;\n", colName, tsType)
-						}
-					} else {
-						colName := c.GetName()
-						tsType := tsTypeMap.toTsType(c)
-						fmt.Fprintf(querier, "  %s: %s;\n", colName, tsType)
+		// 手書きのクエリと同じ名前になる CRUD のクエリは、どちらを使うか決められないので衝突としてエラーにする
+		// 衝突した CRUD のクエリは生成される全ての名前が衝突するので書き出さない
+		queryNames := names.scope("query names")
+		for _, q := range request.GetQueries() {
+			queryNames.declare(q.GetName(), querySource(q))
+		}
+		for _, s := range request.GetCatalog().GetSchemas() {
+			for _, t := range s.GetTables() {
+				if !crudAll && !crudTables[t.GetRel().GetName()] {
+					continue
+				}
+				for _, q := range buildCrudQueries(tableMap, t) {
+					if queryNames.declare(q.GetName(), crudSource(t.GetRel())) {
+						g.writeQuery(querier, q)
 					}
 				}
-				querier.WriteString("};\n")
-
-				querier.WriteByte('\n')
-			}
-
-			rowType := naming.toQueryRowTypeName(q)
-			// retType は関数の戻り値の型
-			var retType string
-			// resultType は SQLite からの戻り値の型
-			var resultType string
-
-			if cmd := q.GetCmd(); cmd == ":one" {
-				retType = rowType + " | null"
-				resultType = retType
-				if needRawType {
-					resultType = naming.toRawQueryRowTypeName(q) + " | null"
+				if q := crudCreateQuery(t); queryNames.declare(q.GetName(), crudSource(t.GetRel())) {
+					g.writeCrudCreate(querier, t, q)
 				}
-			} else if cmd == ":exec" {
-				retType = "D1Result"
-			} else {
-				retType = "D1Result<" + rowType + ">"
-				resultType = rowType
-				if needRawType {
-					resultType = naming.toRawQueryRowTypeName(q)
+				if q := crudUpdateQuery(tableMap, t); q != nil && queryNames.declare(q.GetName(), crudSource(t.GetRel())) {
+					g.writeCrudUpdate(querier, t, q)
 				}
 			}
+		}
 
-			fmt.Fprintf(querier, "export function %s(\n", naming.toFunctionName(q))
-			fmt.Fprintf(querier, "  d1: D1Database")
-			// パラメータがないときは引数を追加しない
-			if len(q.GetParams()) > 0 {
-				querier.WriteString(",\n")
-				fmt.Fprintf(querier, "  args: %s", naming.toParamsTypeName(q))
-			}
-			querier.WriteString("\n")
-			fmt.Fprintf(querier, "): Query<%s> {\n", retType)
-
-			var queryVar string
-			var bindArgs string
-			if hasSqlcSlice(q) {
-				// SQLite はパラメータに配列を指定できないため、sqlc.slice では実行時にクエリを書き換える必要がある
-				// sqlc はパラメータに自動採番する都合で sqlc.slice のパラメータは登場順で番号がつく
-				// しかし ? には番号がついてない文字列が出力される (sqlc-dev/sqlc/pull/2274)
-				// 動的にパラメータの数が変動するが既存のパラメータの番号は書き換えたくないので1個目の要素はそのまま渡して動的なパラメータは末尾に追加する
-				// 例:
-				//  クエリ:
-				//    SELECT * FROM foo WHERE a = @a AND id IN (sqlc.slice(ids)) AND b = @b
-				//  コンパイル済み:
-				//    SELECT id, a, b FROM foo WHERE a = ?1 AND id IN (/*SLICE:ids*/?) AND b = ?3
-				//  実行時(idsが長さ3の場合):
-				//    SELECT id, a, b FROM foo WHERE a = ?1 AND id IN (?2, ?4, ?5) AND b = ?3
-				fmt.Fprintf(querier, "  let query = %s;\n", naming.toConstQueryName(q))
-				fmt.Fprintf(querier, "  const params: any[] = [%s];\n", buildBindArgs(q))
-				for _, p := range q.GetParams() {
-					c := p.GetColumn()
-					if !c.GetIsSqlcSlice() {
-						continue
-					}
-					n := p.GetNumber()
-					propName := naming.toPropertyName(c)
-					// sqlc.slice は (/*SLICE:foo*/?) という形式でクエリが書き出される (sqlc-dev/sqlc/pull/2274)
-					// (?1, ?2, ?3) のような形で書き換える
-					fmt.Fprintf(querier, "  query = query.replace(\"(/*SLICE:%s*/?)\", expandedParam(%d, args.%s.length, params.length));\n", c.Name, n, propName)
-					// 1番目の要素は宣言時に params に含まれているのでそれ以降を push する
-					fmt.Fprintf(querier, "  params.push(...args.%s.slice(1));\n", propName)
-				}
-				queryVar = "query"
-				bindArgs = "...params"
-				requireExpandedParams = true
-			} else {
-				queryVar = naming.toConstQueryName(q)
-				bindArgs = buildBindArgs(q)
-			}
+		for _, t := range g.modelMappings {
+			g.writeModelMapping(querier, t)
+		}
 
-			fmt.Fprintf(querier, "  const ps = d1\n")
-			fmt.Fprintf(querier, "    .prepare(%s)", queryVar)
-			if len(q.GetParams()) > 0 {
-				querier.WriteString("\n")
-				fmt.Fprintf(querier, "    .bind(%s)", bindArgs)
+		if emitHooks {
+			g.writeQueriesFactory(querier)
+		}
+
+		if len(g.explainEntries) > 0 {
+			for _, name := range []string{"QueryPlanRow", "RawQueryPlanRow", "parseQueryPlan", "QueryPlanReport", "ExplainOptions", "checkQueryPlans"} {
+				module.declare(name, "sqlc-gen-ts-d1")
 			}
-			querier.WriteString(";\n")
-
-			fmt.Fprintf(querier, "  return {\n")
-			fmt.Fprintf(querier, "    then(onFulfilled?: (value: %s) => void, onRejected?: (reason?: any) => void) {\n", retType)
-
-			switch q.GetCmd() {
-			case ":one":
-				fmt.Fprintf(querier, "      ps.first<%s>()\n", resultType)
-			case ":many":
-				fmt.Fprintf(querier, "      ps.all<%s>()\n", resultType)
-			case ":exec":
-				fmt.Fprintf(querier, "      ps.run()\n")
+			querier.WriteString(explainRuntime)
+			var tables []string
+			for _, s := range request.GetCatalog().GetSchemas() {
+				for _, t := range s.GetTables() {
+					tables = append(tables, t.GetRel().GetName())
+				}
 			}
+			g.writeExplainAll(querier, tables)
+		}
 
-			// 內部結果型を使っている場合は結果型に変換する処理を生成する
-			if needRawType {
-				if q.GetCmd() == ":one" {
-					fmt.Fprintf(querier, "        .then((raw: %s) => raw ? {\n", resultType)
-					writeFromRawMapping(querier, "          ", tableMap, q)
-					fmt.Fprintf(querier, "        } : null)\n")
-				} else {
-					fmt.Fprintf(querier, "        .then((r: D1Result<%s>) => { return {\n", resultType)
-					fmt.Fprintf(querier, "          ...r,\n")
-					if workersTypesV3 {
-						fmt.Fprintf(querier, "          results: r.results ? r.results.map((raw: %s) => { return {\n", resultType)
-						writeFromRawMapping(querier, "             ", tableMap, q)
-						fmt.Fprintf(querier, "          }}) : undefined,\n")
-					} else {
-						fmt.Fprintf(querier, "          results: r.results.map((raw: %s) => { return {\n", resultType)
-						writeFromRawMapping(querier, "            ", tableMap, q)
-						fmt.Fprintf(querier, "          }}),\n")
-					}
-					fmt.Fprintf(querier, "        }})\n")
-				}
+		if g.requireLoader {
+			for _, name := range []string{"Loader", "maxLoaderKeys", "createLoader"} {
+				module.declare(name, "sqlc-gen-ts-d1")
 			}
-			fmt.Fprintf(querier, "        .then(onFulfilled).catch(onRejected);\n")
-			fmt.Fprintf(querier, "    },\n")
-			fmt.Fprintf(querier, "    batch() { return ps; },\n")
-			fmt.Fprintf(querier, "  }\n")
-			querier.WriteString("}\n")
+			querier.WriteString(loaderRuntime)
+		}
 
-			querier.WriteByte('\n')
+		if g.requirePaginate {
+			module.declare("PageOptions", "sqlc-gen-ts-d1")
+			module.declare("pageSize", "sqlc-gen-ts-d1")
+			module.declare("eachRow", "sqlc-gen-ts-d1")
+			querier.WriteString(paginateRuntime)
 		}
 
-		if requireExpandedParams {
+		if g.requireExpandedParams {
+			module.declare("expandedParam", "sqlc-gen-ts-d1")
 			// sqlc.slice は実行時にクエリ書き換えが必要でその際に使う関数
 			querier.WriteString(`function expandedParam(n: number, len: number, last: number): string {
   const params: number[] = [n];
@@ -307,18 +348,70 @@ func handler(request *plugin.CodeGenRequest) (*plugin.CodeGenResponse, error) {
 `)
 		}
 
-		if len(requireModels) > 0 {
+		for _, s := range request.GetCatalog().GetSchemas() {
+			for _, t := range s.GetTables() {
+				rel := t.GetRel()
+				for _, name := range []string{naming.toModelTypeName(rel), naming.toInsertModelTypeName(rel), naming.toUpdateModelTypeName(rel)} {
+					if g.requireModels[name] {
+						module.declare(name, tableSource(rel)+" (models.ts)")
+					}
+				}
+			}
+		}
+		if len(g.requireModels) > 0 {
 			var models []string
-			for k := range requireModels {
+			for k := range g.requireModels {
 				models = append(models, k)
 			}
 			sort.Strings(models)
-			fmt.Fprintf(header, "import { %s } from \"./models\"\n", strings.Join(models, ", "))
+			fmt.Fprintf(header, "import type { %s } from \"./models\"\n", strings.Join(models, ", "))
+		}
+		if emitErrors {
+			module.declare("wrapD1Error", "errors.ts")
+			header.WriteString("import { wrapD1Error } from \"./errors\"\n")
 		}
 		if header.Len() > 0 {
 			header.WriteString("\n")
 		}
 		files = append(files, &plugin.File{Name: "querier.ts", Contents: append(header.Bytes(), querier.Bytes()...)})
+
+		if emitManifest {
+			manifest, err := marshalManifest(g.manifest)
+			if err != nil {
+				return nil, fmt.Errorf("marshal queries.json: %w", err)
+			}
+			files = append(files, &plugin.File{Name: "queries.json", Contents: manifest})
+		}
+	}
+
+	if emitErrors {
+		// 制約違反のエラーのテーブルとカラムはスキーマから絞り込む
+		errorsFile := bytes.NewBuffer(nil)
+		appendMeta(errorsFile, request)
+		writeErrors(errorsFile, request.GetCatalog())
+		files = append(files, &plugin.File{Name: "errors.ts", Contents: errorsFile.Bytes()})
+	}
+
+	if emitVerify {
+		files = append(files, types.file(request, names, "verify.ts", []string{"D1Database", "D1Result"}, func(w *bytes.Buffer) {
+			writeVerify(w, request.GetCatalog())
+		}))
+	}
+	if emitSchema {
+		files = append(files, types.file(request, names, "schema.ts", []string{"D1Database", "D1Result"}, func(w *bytes.Buffer) {
+			writeSchema(w, tableMap, request.GetCatalog())
+		}))
+	}
+	if emitCatalogSnapshot {
+		snapshot, err := marshalSnapshot(buildSnapshot(tableMap, request.GetCatalog()))
+		if err != nil {
+			return nil, fmt.Errorf("marshal catalog.snapshot.json: %w", err)
+		}
+		files = append(files, &plugin.File{Name: "catalog.snapshot.json", Contents: snapshot})
+	}
+
+	if len(names.errs) > 0 {
+		return nil, fmt.Errorf("generated names collide:\n%w", errors.Join(names.errs...))
 	}
 
 	return &plugin.CodeGenResponse{
@@ -326,6 +419,644 @@ func handler(request *plugin.CodeGenRequest) (*plugin.CodeGenResponse, error) {
 	}, nil
 }
 
+// queryWriter はクエリごとに関数と型を querier.ts に書き出す
+type queryWriter struct {
+	tableMap  TableMap
+	tsTypeMap *TsTypeMap
+	// resultsOptional が true の場合は D1Result の results が undefined の場合を考慮する
+	resultsOptional bool
+
+	// requireModels は models.ts から import が必要な型
+	requireModels map[string]bool
+	// requireExpandedParams は sqlc.slice の展開に使う関数が必要かどうか
+	requireExpandedParams bool
+	// modelMappings は内部結果型からモデルの型への変換処理が必要なテーブル
+	modelMappings        []*plugin.Table
+	requireModelMappings map[string]bool
+
+	// scalarAll が true の場合は全ての対象のクエリ、false の場合は scalarQueries に含まれるクエリの結果を値で返す
+	scalarAll     bool
+	scalarQueries map[string]bool
+	// manyReturnArray が true の場合は :many のクエリの結果を D1Result ではなく配列で返す
+	manyReturnArray bool
+
+	// emitQueryMeta が true の場合はクエリごとに QueryMeta の定数を書き出す
+	emitQueryMeta bool
+	// emitErrors が true の場合は制約違反のエラーを errors.ts の ConstraintError に変換する
+	emitErrors bool
+	// emitHooks が true の場合はフックを呼び出しながらクエリを実行し、factoryEntries から createQueries を書き出す
+	emitHooks      bool
+	factoryEntries []factoryEntry
+	// emitManifest が true の場合はクエリの情報を manifest に集めて queries.json に書き出す
+	emitManifest bool
+	manifest     []manifestQuery
+	// cacheEnabled が true の場合は @cache のクエリの結果をキャッシュし、書き込みのクエリで書き込んだテーブルのキャッシュを無効にする
+	cacheEnabled bool
+	// emitLoaders が true の場合は Loader を生成できる全てのクエリで Loader を返す関数を書き出す
+	// requireLoader は Loader を返す関数を書き出したかどうか
+	emitLoaders   bool
+	requireLoader bool
+	// requirePaginate は @paginate のクエリのページごとの結果を返す関数を書き出したかどうか
+	requirePaginate bool
+	// emitExplain が true の場合はクエリごとに EXPLAIN QUERY PLAN を実行する関数を書き出し、explainEntries から explainAll を書き出す
+	emitExplain    bool
+	explainEntries []explainEntry
+
+	// queryAnnotations はクエリの名前からそのアノテーションへの対応
+	queryAnnotations map[string]*QueryAnnotations
+
+	// names と module は生成するコードの名前の衝突を検出するために使う
+	names  *nameChecker
+	module *nameScope
+}
+
+// annotations はクエリのアノテーションを返す
+// CRUD のクエリのように sqlc から渡されていないクエリは空のアノテーションを返す
+func (g *queryWriter) annotations(q *plugin.Query) *QueryAnnotations {
+	if a, ok := g.queryAnnotations[q.GetName()]; ok {
+		return a
+	}
+	return &QueryAnnotations{}
+}
+
+// scalarColumn は結果を値で返すクエリの場合にそのカラムを返す
+// sqlc.embed ではないカラムを1つだけ返す :one と :many のクエリが対象になる
+// @returns アノテーションがある場合はオプションより優先する
+func (g *queryWriter) scalarColumn(q *plugin.Query) *plugin.Column {
+	switch g.annotations(q).returns {
+	case "row":
+		return nil
+	case "":
+		if !g.scalarAll && !g.scalarQueries[q.GetName()] {
+			return nil
+		}
+	}
+	if !isScalarQuery(q) {
+		return nil
+	}
+	return q.GetColumns()[0]
+}
+
+func isScalarQuery(q *plugin.Query) bool {
+	if cmd := q.GetCmd(); cmd != ":one" && cmd != ":many" {
+		return false
+	}
+	return len(q.GetColumns()) == 1 && q.GetColumns()[0].GetEmbedTable().GetName() == ""
+}
+
+// columnComment はクエリのカラムやパラメータに対応するスキーマのコメントを返す
+// sqlc.embed の場合はテーブルのコメントを返す
+func (g *queryWriter) columnComment(c *plugin.Column) string {
+	if et := c.GetEmbedTable(); et.GetName() != "" {
+		return g.tableMap.findTable(et).GetComment()
+	}
+	if tc := g.tableMap.findColumn(c); tc != nil && tc.GetComment() != "" {
+		return tc.GetComment()
+	}
+	return c.GetComment()
+}
+
+// findRowTable はクエリの結果がテーブルのカラムと順番と型が一致する場合にそのテーブルを返す
+func (g *queryWriter) findRowTable(q *plugin.Query) *plugin.Table {
+	if q.GetCmd() == ":exec" || len(q.GetColumns()) == 0 {
+		return nil
+	}
+	t := g.tableMap.findTable(q.GetColumns()[0].GetTable())
+	if t == nil || len(t.GetColumns()) != len(q.GetColumns()) {
+		return nil
+	}
+	for i, c := range q.GetColumns() {
+		tc := t.GetColumns()[i]
+		if c.GetEmbedTable().GetName() != "" || c.GetTable().GetName() != t.GetRel().GetName() || c.GetName() != tc.GetName() {
+			return nil
+		}
+		if g.tsTypeMap.toTsType(c) != g.tsTypeMap.toTsType(tc) {
+			return nil
+		}
+	}
+	return t
+}
+
+// writeModelMapping はテーブルの内部結果型とモデルの型に変換する関数を書き出す
+func (g *queryWriter) writeModelMapping(w *bytes.Buffer, t *plugin.Table) {
+	rel := t.GetRel()
+	g.module.declare(naming.toRawModelTypeName(rel), tableSource(rel))
+	g.module.declare(naming.toFromRawFunctionName(rel), tableSource(rel))
+	fmt.Fprintf(w, "type %s = {\n", naming.toRawModelTypeName(rel))
+	for _, c := range t.GetColumns() {
+		fmt.Fprintf(w, "  %s: %s;\n", propertyKey(c.GetName()), g.tsTypeMap.toTsType(c))
+	}
+	w.WriteString("};\n")
+
+	w.WriteByte('\n')
+
+	fmt.Fprintf(w, "function %s(raw: %s): %s {\n", naming.toFromRawFunctionName(rel), naming.toRawModelTypeName(rel), naming.toModelTypeName(rel))
+	fmt.Fprintf(w, "  return {\n")
+	writeFromRawMapping(w, "    ", g.tableMap, &plugin.Query{Columns: t.GetColumns()}, nil)
+	fmt.Fprintf(w, "  };\n")
+	w.WriteString("}\n")
+
+	w.WriteByte('\n')
+}
+
+// needModelRawType はテーブルのカラム名とプロパティ名が異なり変換が必要かどうかを返す
+func needModelRawType(t *plugin.Table) bool {
+	for _, c := range t.GetColumns() {
+		if c.GetName() != naming.toPropertyName(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *queryWriter) writeQuery(w *bytes.Buffer, q *plugin.Query) {
+	g.writeQueryText(w, q)
+	optionalParams := g.writeParamsType(w, q)
+	needRawType := g.writeRowTypes(w, q)
+	retType, resultType := g.resultTypes(q, needRawType)
+
+	var paramDocs [][2]string
+	for _, p := range q.GetParams() {
+		c := p.GetColumn()
+		paramDocs = append(paramDocs, [2]string{propertyAccess("args", naming.toPropertyName(c)), g.columnComment(c)})
+	}
+	if g.emitHooks {
+		paramDocs = append(paramDocs, [2]string{"hooks", "省略した場合は setQueryHooks で設定したフックを使う"})
+	}
+	writeFunctionDoc(w, q, g.annotations(q), paramDocs)
+
+	g.module.declare(naming.toFunctionName(q), querySource(q))
+	fmt.Fprintf(w, "export function %s(\n", naming.toFunctionName(q))
+	params := [][2]string{{"d1", "D1Queryable"}}
+	call := queryCall{args: "undefined"}
+	// パラメータがないときは引数を追加しない
+	if len(q.GetParams()) > 0 {
+		params = append(params, [2]string{"args", naming.toParamsTypeName(q)})
+		call.args = "args"
+	}
+	g.writeParams(w, naming.toFunctionName(q), params)
+	if g.isArrayMany(q) {
+		fmt.Fprintf(w, "): ManyQuery<%s> {\n", naming.toQueryRowTypeName(q))
+	} else {
+		fmt.Fprintf(w, "): Query<%s> {\n", retType)
+	}
+
+	call.sql = g.writeStatement(w, q, optionalParams, false)
+	g.writeQueryObject(w, q, call, retType, resultType, needRawType)
+	w.WriteString("}\n")
+
+	w.WriteByte('\n')
+
+	g.writeQueryMeta(w, q)
+	g.writeLoader(w, q)
+	g.writePaginate(w, q)
+	g.writeExplain(w, q, optionalParams)
+}
+
+// writeQueryMeta はクエリの情報の定数を書き出す
+// ルーターが D1Database.withSession() の制約を選べるようにデータを変更するかどうかを含める
+func (g *queryWriter) writeQueryMeta(w *bytes.Buffer, q *plugin.Query) {
+	if !g.emitQueryMeta {
+		return
+	}
+	g.module.declare(naming.toQueryMetaName(q), querySource(q))
+	fmt.Fprintf(w, "export const %s: QueryMeta = {\n", naming.toQueryMetaName(q))
+	fmt.Fprintf(w, "  name: %s,\n", jsQuote(q.GetName()))
+	fmt.Fprintf(w, "  cmd: %s,\n", jsQuote(q.GetCmd()))
+	fmt.Fprintf(w, "  readonly: %t,\n", g.annotations(q).isReadOnly(q))
+	reads, writes := queryTableDeps(g.tableMap, q)
+	fmt.Fprintf(w, "  reads: %s,\n", jsStringArray(reads))
+	fmt.Fprintf(w, "  writes: %s,\n", jsStringArray(writes))
+	w.WriteString("};\n")
+
+	w.WriteByte('\n')
+}
+
+// writeQueryText はクエリ文字列の定数を書き出す
+func (g *queryWriter) writeQueryText(w *bytes.Buffer, q *plugin.Query) {
+	query := g.finalQueryText(q)
+	g.module.declare(naming.toConstQueryName(q), querySource(q))
+	fmt.Fprintf(w, "const %s = `%s`;\n", naming.toConstQueryName(q), escapeTemplateLiteral(query))
+	g.addManifestQuery(q, query)
+
+	w.WriteByte('\n')
+}
+
+// finalQueryText は生成したコードが D1 に渡すクエリ文字列を返す
+func (g *queryWriter) finalQueryText(q *plugin.Query) string {
+	queryText := q.GetText()
+	// sqlc.embed はカラムを x.a, x.b, x.c のような形で展開する
+	// 複数の sqlc.embed が展開された結果、重複した名前のカラムの情報が得られない処理系がある
+	// そのため x.a AS x_a, x.b AS x_b, x.c AS x_c のようにクエリを書き換えることで問題を回避する
+	// カラムを一つずつ書き換えた場合は前方一致や後方一致を考慮する必要があるのでまとめて書き換えを行う
+	for _, c := range q.GetColumns() {
+		et := c.GetEmbedTable()
+		if et.GetName() == "" {
+			continue
+		}
+		var news, olds []string
+		for _, ec := range g.tableMap.findTable(et).GetColumns() {
+			from := et.GetName() + "." + ec.GetName()
+			to := from + " AS " + quoteIdent(naming.toEmbedColumnName(et, ec))
+			olds = append(olds, from)
+			news = append(news, to)
+		}
+		queryText = strings.Replace(queryText, strings.Join(olds, ", "), strings.Join(news, ", "), 1)
+	}
+
+	return "-- name: " + q.GetName() + " " + q.GetCmd() + "\n" + queryText
+}
+
+// writeParamsType はクエリのパラメータ型を書き出し、省略可能なパラメータのプロパティ名を返す
+func (g *queryWriter) writeParamsType(w *bytes.Buffer, q *plugin.Query) map[string]bool {
+	// INSERT のパラメータがテーブルのカラムに対応する場合は INSERT 用のモデルの型を使う
+	// 省略可能なパラメータは undefined になりうるので bind の際に null に変換する
+	insertColumns := g.tableMap.findInsertColumns(q)
+	// @param で型を指定した場合はモデルの型を使わずにパラメータごとに型を書き出す
+	paramTypes := g.annotations(q).paramTypes
+	if len(paramTypes) > 0 {
+		insertColumns = nil
+	}
+	optionalParams := map[string]bool{}
+	if len(q.GetParams()) > 0 {
+		g.module.declare(naming.toParamsTypeName(q), querySource(q))
+		props := g.names.scope(querySource(q) + ": " + naming.toParamsTypeName(q))
+		for _, p := range q.GetParams() {
+			props.declare(naming.toPropertyName(p.GetColumn()), paramSource(p))
+		}
+	}
+	if insertColumns != nil {
+		table := q.GetInsertIntoTable()
+		insertModel := naming.toInsertModelTypeName(table)
+		g.requireModels[insertModel] = true
+		var picks []string
+		for _, c := range insertColumns {
+			propName := naming.toPropertyName(c)
+			picks = append(picks, jsQuote(propName))
+			if g.tableMap.isInsertOptional(table, c) {
+				optionalParams[propName] = true
+			}
+		}
+		writeDoc(w, "", queryDocLines(q))
+		if len(insertColumns) == len(g.tableMap.findTable(table).GetColumns()) {
+			fmt.Fprintf(w, "export type %s = %s;\n", naming.toParamsTypeName(q), insertModel)
+		} else {
+			fmt.Fprintf(w, "export type %s = Pick<%s, %s>;\n", naming.toParamsTypeName(q), insertModel, strings.Join(picks, " | "))
+		}
+
+		w.WriteByte('\n')
+	} else if len(q.GetParams()) > 0 {
+		// パラメータが0個の場合は引数から削除するので型を生成しない
+		writeDoc(w, "", queryDocLines(q))
+		fmt.Fprintf(w, "export type %s = {\n", naming.toParamsTypeName(q))
+		for _, p := range q.GetParams() {
+			c := p.GetColumn()
+			writeDoc(w, "  ", commentLines(g.columnComment(c)))
+			paramName := naming.toPropertyName(c)
+			tsType := g.tsTypeMap.toTsType(c)
+			// パラメータは sqlc.narg を使った場合のみ nullable
+			if c.GetNotNull() {
+				// パラメータに対応するカラムがわかっていて、スキーマ上で nullable であればパラメータを nullable とする
+				if tc := g.tableMap.findColumn(c); tc != nil && !tc.GetNotNull() {
+					tsType += " | null"
+				}
+			}
+			if t, ok := paramTypes[paramName]; ok {
+				tsType = t
+			}
+			fmt.Fprintf(w, "  %s: %s;\n", propertyKey(paramName), tsType)
+		}
+		w.WriteString("};\n")
+
+		w.WriteByte('\n')
+	}
+	return optionalParams
+}
+
+// writeRowTypes はクエリの結果型を書き出し、内部結果型が必要かどうかを返す
+func (g *queryWriter) writeRowTypes(w *bytes.Buffer, q *plugin.Query) bool {
+	// 結果を値で返す場合は結果型が不要
+	if g.scalarColumn(q) != nil {
+		return false
+	}
+
+	if q.GetCmd() != ":exec" {
+		g.module.declare(naming.toQueryRowTypeName(q), querySource(q))
+	}
+
+	// 結果がテーブルのカラムと一致する場合はモデルの型を使い、内部結果型と変換処理はテーブルごとに共有する
+	if t := g.findRowTable(q); t != nil {
+		modelName := naming.toModelTypeName(t.GetRel())
+		g.requireModels[modelName] = true
+		writeDoc(w, "", queryDocLines(q))
+		fmt.Fprintf(w, "export type %s = %s;\n", naming.toQueryRowTypeName(q), modelName)
+
+		w.WriteByte('\n')
+
+		needRawType := needModelRawType(t)
+		if needRawType && !g.requireModelMappings[modelName] {
+			g.requireModelMappings[modelName] = true
+			g.modelMappings = append(g.modelMappings, t)
+		}
+		return needRawType
+	}
+
+	needRawType := false
+	nullableEmbeds := g.annotations(q).nullableEmbeds
+	// :exec はレスポンスが返ってこないので型を生成しない
+	if q.GetCmd() != ":exec" {
+		props := g.names.scope(querySource(q) + ": " + naming.toQueryRowTypeName(q))
+		writeDoc(w, "", queryDocLines(q))
+		fmt.Fprintf(w, "export type %s = {\n", naming.toQueryRowTypeName(q))
+		for _, c := range q.GetColumns() {
+			writeDoc(w, "  ", commentLines(g.columnComment(c)))
+			colName := c.GetName()
+			propName := naming.toPropertyName(c)
+			props.declare(propName, columnSource(c))
+
+			// カラム名(snake)とプロパティ名(camel)が異なる場合
+			// 生成コードの内部で変換する必要があるのでクエリの内部結果型が必要になる
+			if colName != propName {
+				needRawType = true
+			}
+
+			tsType := ""
+
+			// sqlc.embed が使われている場合
+			// 生成コードの内部で変換する必要があるのでクエリの内部結果型が必要になる
+			if et := c.GetEmbedTable(); et.GetName() != "" {
+				needRawType = true
+				tsType = naming.toModelTypeName(et)
+				// models.ts から import が必要になる
+				g.requireModels[tsType] = true
+				// @nullable-embed は LEFT JOIN で対応する行がない場合に null になる
+				if nullableEmbeds[c.GetName()] {
+					tsType += " | null"
+				}
+			} else {
+				tsType = g.tsTypeMap.toTsType(c)
+			}
+			fmt.Fprintf(w, "  %s: %s;\n", propertyKey(propName), tsType)
+		}
+		w.WriteString("};\n")
+
+		w.WriteByte('\n')
+	}
+
+	// 內部結果型が必要な場合のみ生成する
+	if needRawType {
+		g.module.declare(naming.toRawQueryRowTypeName(q), querySource(q))
+		// 内部結果型のキーは SQLite の結果のカラム名なので、重複すると片方の値が失われる
+		keys := g.names.scope(querySource(q) + ": " + naming.toRawQueryRowTypeName(q))
+		fmt.Fprintf(w, "type %s = {\n", naming.toRawQueryRowTypeName(q))
+		for _, c := range q.GetColumns() {
+			// sqlc.embed の場合、スキーマからカラムの情報を取得し展開する
+			if et := c.GetEmbedTable(); et.GetName() != "" {
+				for _, ec := range g.tableMap.findTable(et).GetColumns() {
+					colName := naming.toEmbedColumnName(et, ec)
+					keys.declare(colName, embedColumnSource(et, ec))
+					tsType := g.tsTypeMap.toTsType(ec)
+					if nullableEmbeds[c.GetName()] && ec.GetNotNull() {
+						tsType += " | null"
+					}
+					fmt.Fprintf(w, "  %s: %s;\n", propertyKey(colName), tsType)
+				}
+			} else {
+				colName := c.GetName()
+				keys.declare(colName, columnSource(c))
+				tsType := g.tsTypeMap.toTsType(c)
+				fmt.Fprintf(w, "  %s: %s;\n", propertyKey(colName), tsType)
+			}
+		}
+		w.WriteString("};\n")
+
+		w.WriteByte('\n')
+	}
+	return needRawType
+}
+
+// resultTypes は関数の戻り値の型(retType)と SQLite からの戻り値の型(resultType)を返す
+func (g *queryWriter) resultTypes(q *plugin.Query, needRawType bool) (retType, resultType string) {
+	rowType := naming.toQueryRowTypeName(q)
+	rawType := naming.toRawQueryRowTypeName(q)
+	if t := g.findRowTable(q); t != nil {
+		rawType = naming.toRawModelTypeName(t.GetRel())
+	}
+
+	// 結果を値で返す場合は :one は値か null を、:many は値の配列を返す
+	// :many は raw() で各行を配列として受け取るので resultType は要素が1個のタプルになる
+	if c := g.scalarColumn(q); c != nil {
+		tsType := g.tsTypeMap.toTsType(c)
+		if q.GetCmd() == ":one" {
+			retType = tsType
+			if c.GetNotNull() {
+				retType += " | null"
+			}
+			return retType, tsType
+		}
+		if strings.Contains(tsType, " | ") {
+			return "(" + tsType + ")[]", "[" + tsType + "]"
+		}
+		return tsType + "[]", "[" + tsType + "]"
+	}
+
+	if cmd := q.GetCmd(); cmd == ":one" {
+		retType = rowType + " | null"
+		resultType = retType
+		if needRawType {
+			resultType = rawType + " | null"
+		}
+	} else if cmd == ":exec" {
+		retType = "D1Result"
+	} else {
+		retType = "D1Result<" + rowType + ">"
+		if g.isArrayMany(q) {
+			retType = rowType + "[]"
+		}
+		resultType = rowType
+		if needRawType {
+			resultType = rawType
+		}
+	}
+	return retType, resultType
+}
+
+// writeStatement はパラメータを bind した D1PreparedStatement を ps として宣言し、実行する SQL の変数を返す
+// explain が true の場合はクエリの SQL の代わりに EXPLAIN QUERY PLAN を実行する ps を書き出す
+func (g *queryWriter) writeStatement(w *bytes.Buffer, q *plugin.Query, optionalParams map[string]bool, explain bool) string {
+	var queryVar string
+	var bindArgs string
+	if hasSqlcSlice(q) {
+		// SQLite はパラメータに配列を指定できないため、sqlc.slice では実行時にクエリを書き換える必要がある
+		// sqlc はパラメータに自動採番する都合で sqlc.slice のパラメータは登場順で番号がつく
+		// しかし ? には番号がついてない文字列が出力される (sqlc-dev/sqlc/pull/2274)
+		// 動的にパラメータの数が変動するが既存のパラメータの番号は書き換えたくないので1個目の要素はそのまま渡して動的なパラメータは末尾に追加する
+		// 例:
+		//  クエリ:
+		//    SELECT * FROM foo WHERE a = @a AND id IN (sqlc.slice(ids)) AND b = @b
+		//  コンパイル済み:
+		//    SELECT id, a, b FROM foo WHERE a = ?1 AND id IN (/*SLICE:ids*/?) AND b = ?3
+		//  実行時(idsが長さ3の場合):
+		//    SELECT id, a, b FROM foo WHERE a = ?1 AND id IN (?2, ?4, ?5) AND b = ?3
+		fmt.Fprintf(w, "  let query = %s;\n", naming.toConstQueryName(q))
+		fmt.Fprintf(w, "  const params: any[] = [%s];\n", buildBindArgs(q, optionalParams))
+		for _, p := range q.GetParams() {
+			c := p.GetColumn()
+			if !c.GetIsSqlcSlice() {
+				continue
+			}
+			n := p.GetNumber()
+			arg := propertyAccess("args", naming.toPropertyName(c))
+			// sqlc.slice は (/*SLICE:foo*/?) という形式でクエリが書き出される (sqlc-dev/sqlc/pull/2274)
+			// (?1, ?2, ?3) のような形で書き換える
+			fmt.Fprintf(w, "  query = query.replace(%s, expandedParam(%d, %s.length, params.length));\n", jsQuote("(/*SLICE:"+c.GetName()+"*/?)"), n, arg)
+			// 1番目の要素は宣言時に params に含まれているのでそれ以降を push する
+			fmt.Fprintf(w, "  params.push(...%s.slice(1));\n", arg)
+		}
+		queryVar = "query"
+		bindArgs = "...params"
+		g.requireExpandedParams = true
+	} else {
+		queryVar = naming.toConstQueryName(q)
+		bindArgs = buildBindArgs(q, optionalParams)
+	}
+
+	fmt.Fprintf(w, "  const ps = d1\n")
+	if explain {
+		fmt.Fprintf(w, "    .prepare(\"EXPLAIN QUERY PLAN \" + %s)", queryVar)
+	} else {
+		fmt.Fprintf(w, "    .prepare(%s)", queryVar)
+	}
+	if len(q.GetParams()) > 0 {
+		w.WriteString("\n")
+		fmt.Fprintf(w, "    .bind(%s)", bindArgs)
+	}
+	w.WriteString(";\n")
+	return queryVar
+}
+
+// writeArrayResults は many-return=array の :many のクエリで D1Result から結果の配列だけを取り出す処理を書き出す
+func (g *queryWriter) writeArrayResults(w *bytes.Buffer, in string, q *plugin.Query) {
+	if !g.isArrayMany(q) {
+		return
+	}
+	if g.resultsOptional {
+		fmt.Fprintf(w, "%s  .then((r: D1Result<%s>) => r.results ?? [])\n", in, naming.toQueryRowTypeName(q))
+	} else {
+		fmt.Fprintf(w, "%s  .then((r: D1Result<%s>) => r.results)\n", in, naming.toQueryRowTypeName(q))
+	}
+}
+
+// writeQueryObject は ps を実行して結果型に変換する Query を返す処理を書き出す
+// call はフックに渡すクエリの情報で、フックを使わない場合は使われない
+func (g *queryWriter) writeQueryObject(w *bytes.Buffer, q *plugin.Query, call queryCall, retType, resultType string, needRawType bool) {
+	fmt.Fprintf(w, "  return {\n")
+	fmt.Fprintf(w, "    then(onFulfilled?: (value: %s) => void, onRejected?: (reason?: any) => void) {\n", retType)
+	g.writeResultChainWithCache(w, "      ", q, call, resultType, needRawType)
+	g.writeCreatedRowCheck(w, "      ", q, retType)
+	g.writeCacheEviction(w, "      ", q)
+	g.writeErrorCatch(w, "      ", q)
+	fmt.Fprintf(w, "        .then(onFulfilled).catch(onRejected);\n")
+	fmt.Fprintf(w, "    },\n")
+	fmt.Fprintf(w, "    batch() { return ps; },\n")
+	if g.isArrayMany(q) {
+		// meta などの D1Result の情報が必要な場合のために D1Result を返す Query も返せるようにする
+		metaType := "D1Result<" + naming.toQueryRowTypeName(q) + ">"
+		fmt.Fprintf(w, "    withMeta() {\n")
+		fmt.Fprintf(w, "      return {\n")
+		fmt.Fprintf(w, "        then(onFulfilled?: (value: %s) => void, onRejected?: (reason?: any) => void) {\n", metaType)
+		// meta は実行ごとに異なるのでキャッシュを使わない
+		g.writeResultChain(w, "          ", q, call, resultType, needRawType)
+		g.writeCacheEviction(w, "          ", q)
+		g.writeErrorCatch(w, "          ", q)
+		fmt.Fprintf(w, "            .then(onFulfilled).catch(onRejected);\n")
+		fmt.Fprintf(w, "        },\n")
+		fmt.Fprintf(w, "        batch() { return ps; },\n")
+		fmt.Fprintf(w, "      }\n")
+		fmt.Fprintf(w, "    },\n")
+	}
+	fmt.Fprintf(w, "  }\n")
+}
+
+// writeErrorCatch は制約違反のエラーをクエリの名前をつけた ConstraintError にして投げ直す処理を書き出す
+// onFulfilled で投げられたエラーは変換しないように onFulfilled より前に書き出す
+func (g *queryWriter) writeErrorCatch(w *bytes.Buffer, in string, q *plugin.Query) {
+	if !g.emitErrors {
+		return
+	}
+	fmt.Fprintf(w, "%s  .catch((e: unknown) => { throw wrapD1Error(e, %s); })\n", in, jsQuote(q.GetName()))
+}
+
+// isArrayMany は :many のクエリの結果を D1Result ではなく配列で返すかどうかを返す
+func (g *queryWriter) isArrayMany(q *plugin.Query) bool {
+	return g.manyReturnArray && q.GetCmd() == ":many" && g.scalarColumn(q) == nil
+}
+
+// writeResultChain は ps を実行して結果型に変換するまでの Promise のチェーンを書き出す
+// in は ps の行のインデントで、チェーンはそこから2つ下げて書き出す
+func (g *queryWriter) writeResultChain(w *bytes.Buffer, in string, q *plugin.Query, call queryCall, resultType string, needRawType bool) {
+	if g.emitHooks {
+		g.writeObservedExec(w, in, q, call, resultType)
+	} else if c := g.scalarColumn(q); c != nil {
+		// first(column) は1行目のカラムの値を返し、raw() は各行をカラムの値の配列で返す
+		if q.GetCmd() == ":one" {
+			fmt.Fprintf(w, "%sps.first<%s>(%s)\n", in, resultType, jsQuote(c.GetName()))
+		} else {
+			fmt.Fprintf(w, "%sps.raw<%s>()\n", in, resultType)
+			fmt.Fprintf(w, "%s  .then((rows: %s[]) => rows.map((row: %s) => row[0]))\n", in, resultType, resultType)
+		}
+	} else {
+		switch q.GetCmd() {
+		case ":one":
+			fmt.Fprintf(w, "%sps.first<%s>()\n", in, resultType)
+		case ":many":
+			fmt.Fprintf(w, "%sps.all<%s>()\n", in, resultType)
+		case ":exec":
+			fmt.Fprintf(w, "%sps.run()\n", in)
+		}
+	}
+	if g.scalarColumn(q) != nil {
+		return
+	}
+
+	// 內部結果型を使っている場合は結果型に変換する処理を生成する
+	if t := g.findRowTable(q); needRawType && t != nil {
+		// テーブルごとに共有している変換処理を使う
+		fromRaw := naming.toFromRawFunctionName(t.GetRel())
+		if q.GetCmd() == ":one" {
+			fmt.Fprintf(w, "%s  .then((raw: %s) => raw ? %s(raw) : null)\n", in, resultType, fromRaw)
+		} else {
+			fmt.Fprintf(w, "%s  .then((r: D1Result<%s>) => { return {\n", in, resultType)
+			fmt.Fprintf(w, "%s    ...r,\n", in)
+			if g.resultsOptional {
+				fmt.Fprintf(w, "%s    results: r.results ? r.results.map(%s) : undefined,\n", in, fromRaw)
+			} else {
+				fmt.Fprintf(w, "%s    results: r.results.map(%s),\n", in, fromRaw)
+			}
+			fmt.Fprintf(w, "%s  }})\n", in)
+		}
+	} else if needRawType {
+		if q.GetCmd() == ":one" {
+			fmt.Fprintf(w, "%s  .then((raw: %s) => raw ? {\n", in, resultType)
+			writeFromRawMapping(w, in+"    ", g.tableMap, q, g.annotations(q).nullableEmbeds)
+			fmt.Fprintf(w, "%s  } : null)\n", in)
+		} else {
+			fmt.Fprintf(w, "%s  .then((r: D1Result<%s>) => { return {\n", in, resultType)
+			fmt.Fprintf(w, "%s    ...r,\n", in)
+			if g.resultsOptional {
+				fmt.Fprintf(w, "%s    results: r.results ? r.results.map((raw: %s) => { return {\n", in, resultType)
+				writeFromRawMapping(w, in+"       ", g.tableMap, q, g.annotations(q).nullableEmbeds)
+				fmt.Fprintf(w, "%s    }}) : undefined,\n", in)
+			} else {
+				fmt.Fprintf(w, "%s    results: r.results.map((raw: %s) => { return {\n", in, resultType)
+				writeFromRawMapping(w, in+"      ", g.tableMap, q, g.annotations(q).nullableEmbeds)
+				fmt.Fprintf(w, "%s    }}),\n", in)
+			}
+			fmt.Fprintf(w, "%s  }})\n", in)
+		}
+	}
+}
+
 // TableMap はスキーマのテーブルの情報を検索可能なマップ
 type TableMap struct {
 	m map[string]*tableMapEntry
@@ -351,9 +1082,78 @@ func (m *TableMap) findTable(table *plugin.Identifier) *plugin.Table {
 	return t.t
 }
 
+// setPrimaryKeys は `table.column` 形式で指定された主キーを登録する
+// 同じテーブルのカラムを複数指定した場合は複合主キーとして扱う
+// プラグインに渡されるカタログには主キーの情報が含まれないためオプションで指定する
+func (m *TableMap) setPrimaryKeys(keys []string) error {
+	for _, k := range keys {
+		tableName, columnName, ok := strings.Cut(k, ".")
+		if !ok {
+			return fmt.Errorf("invalid primary key %q: must be table.column", k)
+		}
+		table := m.m[tableName]
+		if table == nil {
+			return fmt.Errorf("table not found: %s", tableName)
+		}
+		c := table.m[columnName]
+		if c == nil {
+			return fmt.Errorf("column not found: %s", k)
+		}
+		table.pk = append(table.pk, c)
+	}
+	return nil
+}
+
+// findPrimaryKey はテーブルの主キーのカラムを返す
+func (m *TableMap) findPrimaryKey(table *plugin.Identifier) []*plugin.Column {
+	t := m.m[table.GetName()]
+	if t == nil {
+		return nil
+	}
+	return t.pk
+}
+
+// isRowidAlias はカラムが rowid のエイリアス(INTEGER PRIMARY KEY)かどうかを返す
+// https://www.sqlite.org/lang_createtable.html#rowid
+func (m *TableMap) isRowidAlias(table *plugin.Identifier, c *plugin.Column) bool {
+	pk := m.findPrimaryKey(table)
+	return len(pk) == 1 && pk[0].GetName() == c.GetName() && strings.ToUpper(c.GetType().GetName()) == "INTEGER"
+}
+
+// isInsertOptional は INSERT の際にカラムを省略できるかどうかを返す
+func (m *TableMap) isInsertOptional(table *plugin.Identifier, c *plugin.Column) bool {
+	return !c.GetNotNull() || m.isRowidAlias(table, c)
+}
+
+// findInsertColumns は INSERT のパラメータに対応するテーブルのカラムを返す
+// パラメータがテーブルのカラムと一対一で対応しない場合は nil を返す
+func (m *TableMap) findInsertColumns(q *plugin.Query) []*plugin.Column {
+	table := q.GetInsertIntoTable()
+	if table.GetName() == "" || len(q.GetParams()) == 0 {
+		return nil
+	}
+	seen := map[string]bool{}
+	var columns []*plugin.Column
+	for _, p := range q.GetParams() {
+		c := p.GetColumn()
+		if c.GetIsSqlcSlice() || c.GetTable().GetName() != table.GetName() {
+			return nil
+		}
+		tc := m.findColumn(c)
+		// 名前付きパラメータでカラムと異なる名前が付けられている場合はモデルの型を使えない
+		if tc == nil || naming.toPropertyName(tc) != naming.toPropertyName(c) || seen[tc.GetName()] {
+			return nil
+		}
+		seen[tc.GetName()] = true
+		columns = append(columns, tc)
+	}
+	return columns
+}
+
 type tableMapEntry struct {
-	t *plugin.Table
-	m map[string]*plugin.Column
+	t  *plugin.Table
+	m  map[string]*plugin.Column
+	pk []*plugin.Column
 }
 
 func buildTableMap(catalog *plugin.Catalog) TableMap {
@@ -375,30 +1175,6 @@ func buildTableMap(catalog *plugin.Catalog) TableMap {
 	return tm
 }
 
-// parseOption はクオートされたカンマ区切りの`key=value`形式かjsonのオブジェクト形式の入力を受け取りマップとして返す
-// 例: `"foo1=bar,foo2=buz"` => map[string]string{"foo1": "bar", "foo2": "buz"}
-// 例: `{"foo1":"bar","foo2":"buz"}` => map[string]string{"foo1": "bar", "foo2": "buz"}
-func parseOption(opt []byte) (map[string]string, error) {
-	m := map[string]string{}
-	if len(opt) == 0 {
-		return m, nil
-	}
-
-	if bytes.HasPrefix(opt, []byte("{")) {
-		if err := json.Unmarshal(opt, &m); err != nil {
-			return nil, fmt.Errorf("unmarshal: %w", err)
-		}
-		return m, nil
-	}
-
-	s, _ := strconv.Unquote(string(opt))
-	for _, kv := range strings.Split(s, ",") {
-		k, v, _ := strings.Cut(kv, "=")
-		m[k] = v
-	}
-	return m, nil
-}
-
 type TsTypeMap struct {
 	m map[string]string
 }
@@ -439,7 +1215,9 @@ func toUpperCamel(snake string) string {
 	var b strings.Builder
 	for _, t := range strings.Split(snake, "_") {
 		if t != "" {
-			b.WriteString(strings.ToUpper(t[:1]) + t[1:])
+			r, size := utf8.DecodeRuneInString(t)
+			b.WriteRune(unicode.ToUpper(r))
+			b.WriteString(t[size:])
 		}
 	}
 	return b.String()
@@ -447,50 +1225,149 @@ func toUpperCamel(snake string) string {
 
 func toLowerCamel(snake string) string {
 	s := toUpperCamel(snake)
-	return strings.ToLower(s[:1]) + s[1:]
+	// "_" だけの名前は空文字列になる
+	if s == "" {
+		return ""
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[size:]
 }
 
-type Naming struct{}
+// toSnake は camelCase や PascalCase の名前を snake_case に変換する
+func toSnake(s string) string {
+	var b strings.Builder
+	rs := []rune(s)
+	for i, r := range rs {
+		if unicode.IsUpper(r) {
+			// 大文字が連続する場合(URL など)は単語の区切りとみなさない
+			if i > 0 && rs[i-1] != '_' && (!unicode.IsUpper(rs[i-1]) || i+1 < len(rs) && unicode.IsLower(rs[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+const (
+	// propertyNamingCamel はカラム名を camelCase に変換してプロパティ名にする
+	propertyNamingCamel = "camel"
+	// propertyNamingSnake はカラム名を snake_case に変換してプロパティ名にする
+	propertyNamingSnake = "snake"
+	// propertyNamingPreserve はカラム名をそのままプロパティ名にする
+	propertyNamingPreserve = "preserve"
+)
+
+type Naming struct {
+	// rename はテーブル名やカラム名から生成するコードでの名前への対応 (sqlc の rename 設定)
+	rename map[string]string
+	// propertyNaming はプロパティ名の命名規則
+	propertyNaming string
+	// inflection が nil でない場合はテーブル名を単数形にしてモデルの型名にする
+	inflection *Inflection
+}
 
 // toModelTypeName は models.ts に出力されるモデルの型名を返す
-func (Naming) toModelTypeName(table *plugin.Identifier) string {
-	return toUpperCamel(table.GetName())
+func (n Naming) toModelTypeName(table *plugin.Identifier) string {
+	if s, ok := n.rename[table.GetName()]; ok {
+		return toTypeIdentifier(s)
+	}
+	if n.inflection != nil {
+		return toTypeIdentifier(toUpperCamel(n.inflection.singular(table.GetName())))
+	}
+	return toTypeIdentifier(toUpperCamel(table.GetName()))
+}
+
+// toInsertModelTypeName は models.ts に出力される INSERT 用のモデルの型名を返す
+func (n Naming) toInsertModelTypeName(table *plugin.Identifier) string {
+	return "New" + n.toModelTypeName(table)
+}
+
+// toUpdateModelTypeName は models.ts に出力される UPDATE 用のモデルの型名を返す
+func (n Naming) toUpdateModelTypeName(table *plugin.Identifier) string {
+	return n.toModelTypeName(table) + "Update"
 }
 
 // toPropertyName は TypeScript のプロパティの名前を返す
-func (Naming) toPropertyName(col *plugin.Column) string {
-	return toLowerCamel(col.GetName())
+// 識別子として使えない名前も返すので、書き出す際は propertyKey や propertyAccess を使う
+func (n Naming) toPropertyName(col *plugin.Column) string {
+	if s, ok := n.rename[col.GetName()]; ok {
+		return s
+	}
+	switch n.propertyNaming {
+	case propertyNamingSnake:
+		return toSnake(col.GetName())
+	case propertyNamingPreserve:
+		return col.GetName()
+	default:
+		// "_" だけの名前は変換すると空文字列になるのでそのまま使う
+		if s := toLowerCamel(col.GetName()); s != "" {
+			return s
+		}
+		return col.GetName()
+	}
 }
 
 // toConstQueryName はクエリ文字列の定数の名前を返す
 func (Naming) toConstQueryName(q *plugin.Query) string {
-	return toLowerCamel(q.GetName()) + "Query"
+	return toIdentifier(toLowerCamel(q.GetName()) + "Query")
 }
 
 // toParamsTypeName はクエリのパラメータ型の名前を返す
 func (Naming) toParamsTypeName(q *plugin.Query) string {
-	return q.GetName() + "Params"
+	return toTypeIdentifier(q.GetName() + "Params")
 }
 
 // toQueryRowTypeName はクエリの結果型の名前を返す
 func (Naming) toQueryRowTypeName(q *plugin.Query) string {
-	return q.GetName() + "Row"
+	return toTypeIdentifier(q.GetName() + "Row")
 }
 
 // toRawQueryRowTypeName はクエリの内部結果型の名前を返す
 func (Naming) toRawQueryRowTypeName(q *plugin.Query) string {
-	return "Raw" + q.GetName() + "Row"
+	return toTypeIdentifier("Raw" + q.GetName() + "Row")
+}
+
+// toRawModelTypeName はテーブルの内部結果型の名前を返す
+func (n Naming) toRawModelTypeName(table *plugin.Identifier) string {
+	return "Raw" + n.toModelTypeName(table)
+}
+
+// toFromRawFunctionName はテーブルの内部結果型をモデルの型に変換する関数の名前を返す
+func (n Naming) toFromRawFunctionName(table *plugin.Identifier) string {
+	return "fromRaw" + n.toModelTypeName(table)
 }
 
 // toEmbedColumnName は sqlc.embed が使われたときのカラム名を返す
 func (Naming) toEmbedColumnName(e *plugin.Identifier, c *plugin.Column) string {
 	// MEMO: "_" 1つだと最悪他のカラム名と衝突してしまいそう
+	// 衝突した場合は nameChecker で検出してエラーにする
 	return e.GetName() + "_" + c.GetName()
 }
 
 // toFunctionName はクエリ関数の関数名を返す
 func (Naming) toFunctionName(q *plugin.Query) string {
-	return toLowerCamel(q.GetName())
+	return toIdentifier(toLowerCamel(q.GetName()))
+}
+
+func (Naming) toQueryMetaName(q *plugin.Query) string {
+	return toIdentifier(toLowerCamel(q.GetName()) + "Meta")
+}
+
+// toPaginateName はクエリのページごとの結果を返す関数の名前を返す
+func (Naming) toPaginateName(q *plugin.Query) string {
+	return toIdentifier("paginate" + toUpperCamel(q.GetName()))
+}
+
+// toExplainName はクエリの EXPLAIN QUERY PLAN を実行する関数の名前を返す
+func (Naming) toExplainName(q *plugin.Query) string {
+	return toIdentifier("explain" + toUpperCamel(q.GetName()))
+}
+
+// toLoaderName はクエリの Loader を返す関数の名前を返す
+func (Naming) toLoaderName(q *plugin.Query) string {
+	return toIdentifier(toLowerCamel(q.GetName()) + "Loader")
 }
 
 var naming Naming
@@ -504,36 +1381,56 @@ func hasSqlcSlice(q *plugin.Query) bool {
 	return false
 }
 
-func buildBindArgs(q *plugin.Query) string {
+// buildBindArgs は bind に渡す引数の式を返す
+// optional に含まれるプロパティは省略されうるので undefined を null に変換する
+func buildBindArgs(q *plugin.Query, optional map[string]bool) string {
 	var args strings.Builder
 	for i, p := range q.GetParams() {
 		if i > 0 {
 			args.WriteString(", ")
 		}
-		args.WriteString("args." + naming.toPropertyName(p.GetColumn()))
+		propName := naming.toPropertyName(p.GetColumn())
+		args.WriteString(propertyAccess("args", propName))
 		if p.GetColumn().GetIsSqlcSlice() {
 			args.WriteString("[0]")
 		}
+		if optional[propName] {
+			args.WriteString(" ?? null")
+		}
 	}
 	return args.String()
 }
 
-func writeFromRawMapping(w *bytes.Buffer, indent string, tableMap TableMap, q *plugin.Query) {
+// writeFromRawMapping は内部結果型の raw から結果型のプロパティへの変換を書き出す
+// nullableEmbeds に含まれる sqlc.embed はカラムが全て null の場合に null にする
+func writeFromRawMapping(w *bytes.Buffer, indent string, tableMap TableMap, q *plugin.Query, nullableEmbeds map[string]bool) {
 	for _, c := range q.GetColumns() {
 		propName := naming.toPropertyName(c)
 		// sqlc.embed の場合はモデル型に変換する
 		if et := c.GetEmbedTable(); et.GetName() != "" {
 			fmt.Fprintf(w, "%s// sqlc.embed(%s)\n", indent, propName)
-			fmt.Fprintf(w, "%s%s: {\n", indent, propName)
+			if nullableEmbeds[c.GetName()] {
+				var conds []string
+				for _, ec := range tableMap.findTable(et).GetColumns() {
+					conds = append(conds, propertyAccess("raw", naming.toEmbedColumnName(et, ec))+" === null")
+				}
+				fmt.Fprintf(w, "%s%s: %s ? null : {\n", indent, propertyKey(propName), strings.Join(conds, " && "))
+			} else {
+				fmt.Fprintf(w, "%s%s: {\n", indent, propertyKey(propName))
+			}
 			for _, ec := range tableMap.findTable(et).GetColumns() {
-				from := naming.toEmbedColumnName(et, ec)
+				from := propertyAccess("raw", naming.toEmbedColumnName(et, ec))
 				to := naming.toPropertyName(ec)
-				fmt.Fprintf(w, "%s  %s: raw.%s,\n", indent, to, from)
+				// 内部結果型では NOT NULL のカラムも null になりうるが、全てが null でなければ行があるので null にならない
+				if nullableEmbeds[c.GetName()] && ec.GetNotNull() {
+					from += "!"
+				}
+				fmt.Fprintf(w, "%s  %s: %s,\n", indent, propertyKey(to), from)
 			}
 			fmt.Fprintf(w, "%s},\n", indent)
 		} else {
 			from := c.GetName()
-			fmt.Fprintf(w, "%s%s: raw.%s,\n", indent, propName, from)
+			fmt.Fprintf(w, "%s%s: %s,\n", indent, propertyKey(propName), propertyAccess("raw", from))
 		}
 	}
 }
@@ -591,6 +1488,25 @@ func appendMeta(b *bytes.Buffer, req *plugin.CodeGenRequest) {
 }
 
 func main() {
+	// options-schema はエディタの補完に使うオプションの JSON Schema を出力する
+	if len(os.Args) > 1 && os.Args[1] == "options-schema" {
+		e := json.NewEncoder(os.Stdout)
+		e.SetEscapeHTML(false)
+		e.SetIndent("", "  ")
+		if err := e.Encode(optionsSchema()); err != nil {
+			fmt.Fprintf(os.Stderr, "error generating options schema: %s", err)
+			os.Exit(2)
+		}
+		return
+	}
+	// diff は2つの catalog.snapshot.json から D1 のマイグレーションを作る
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		if err := runDiff(os.Args[2:], os.Stdout, os.Stderr); err != nil {
+			fmt.Fprintf(os.Stderr, "error generating migration: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 	if err := run(handler); err != nil {
 		fmt.Fprintf(os.Stderr, "error generating output: %s", err)
 		os.Exit(2)
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// manifestQuery は queries.json に書き出すクエリの情報
+type manifestQuery struct {
+	Name     string `json:"name"`
+	Cmd      string `json:"cmd"`
+	Filename string `json:"filename,omitempty"`
+	// SQL は生成したコードが D1 に渡す SQL で、Dynamic の場合は書き換える前の SQL
+	SQL string `json:"sql"`
+	// Hash は SQL の sha256 で、SQL が同じであれば生成し直しても変わらない
+	Hash string `json:"hash"`
+	// Dynamic は sqlc.slice などで実行時に SQL が書き換えられるかどうか
+	Dynamic bool `json:"dynamic,omitempty"`
+	// Pattern は Dynamic の場合に実際に D1 に渡す全ての SQL に一致する正規表現
+	Pattern string           `json:"pattern,omitempty"`
+	Params  []manifestParam  `json:"params"`
+	Columns []manifestColumn `json:"columns"`
+	// Tables は Reads と Writes のテーブル
+	Tables []string `json:"tables"`
+	Reads  []string `json:"reads"`
+	Writes []string `json:"writes"`
+}
+
+type manifestParam struct {
+	Number int32  `json:"number"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	TsType string `json:"tsType"`
+	Slice  bool   `json:"slice,omitempty"`
+}
+
+// manifestColumn は SQLite が返す結果のカラムで、sqlc.embed は展開したカラムになる
+type manifestColumn struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	TsType string `json:"tsType"`
+}
+
+// addManifestQuery は queries.json に書き出すクエリを追加する
+// sql は生成したコードの定数に書き出した SQL
+func (g *queryWriter) addManifestQuery(q *plugin.Query, sql string) {
+	if !g.emitManifest {
+		return
+	}
+	m := manifestQuery{
+		Name:     q.GetName(),
+		Cmd:      q.GetCmd(),
+		Filename: q.GetFilename(),
+		SQL:      sql,
+		Hash:     sqlHash(sql),
+		Pattern:  g.dynamicSQLPattern(q, sql),
+		Params:   []manifestParam{},
+		Columns:  []manifestColumn{},
+	}
+	m.Dynamic = m.Pattern != ""
+	m.Reads, m.Writes = queryTableDeps(g.tableMap, q)
+	tables := map[string]bool{}
+	for _, t := range append(m.Reads, m.Writes...) {
+		tables[t] = true
+	}
+	m.Tables = sortedKeys(tables)
+	for _, p := range q.GetParams() {
+		c := p.GetColumn()
+		m.Params = append(m.Params, manifestParam{
+			Number: p.GetNumber(),
+			Name:   naming.toPropertyName(c),
+			Type:   c.GetType().GetName(),
+			TsType: g.tsTypeMap.toTsType(c),
+			Slice:  c.GetIsSqlcSlice(),
+		})
+	}
+	for _, c := range q.GetColumns() {
+		if et := c.GetEmbedTable(); et.GetName() != "" {
+			for _, ec := range g.tableMap.findTable(et).GetColumns() {
+				m.Columns = append(m.Columns, manifestColumn{
+					Name:   naming.toEmbedColumnName(et, ec),
+					Type:   ec.GetType().GetName(),
+					TsType: g.tsTypeMap.toTsType(ec),
+				})
+			}
+			continue
+		}
+		m.Columns = append(m.Columns, manifestColumn{
+			Name:   c.GetName(),
+			Type:   c.GetType().GetName(),
+			TsType: g.tsTypeMap.toTsType(c),
+		})
+	}
+	g.manifest = append(g.manifest, m)
+}
+
+// dynamicSQLPattern は実行時に書き換えられる sql から、実際に D1 に渡す SQL の全てに一致する正規表現を返す
+// 書き換えられない場合は空文字列を返す
+// 許可リストとして JavaScript の RegExp と Go の regexp のどちらでも使えるように、両方で同じ意味になる構文だけを使う
+func (g *queryWriter) dynamicSQLPattern(q *plugin.Query, sql string) string {
+	pattern := regexp.QuoteMeta(sql)
+	for _, p := range q.GetParams() {
+		// expandedParam は (/*SLICE:ids*/?) を (?2, ?4, ?5) のように配列の長さだけパラメータを並べたものに置き換える
+		if c := p.GetColumn(); c.GetIsSqlcSlice() {
+			pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta("(/*SLICE:"+c.GetName()+"*/?)"), `\(\?[0-9]+(?:, \?[0-9]+)*\)`)
+		}
+	}
+	// CRUD の Update は /*SET*/ を指定されたプロパティの "column" = ?N に置き換え、指定されなかった場合は SELECT を実行する
+	if strings.Contains(sql, "/*SET*/") {
+		var assignments []string
+		for _, c := range q.GetColumns() {
+			assignments = append(assignments, regexp.QuoteMeta(quoteIdent(c.GetName())+" = ?")+"[0-9]+")
+		}
+		assignment := "(?:" + strings.Join(assignments, "|") + ")"
+		pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta("/*SET*/"), assignment+"(?:, "+assignment+")*")
+		pattern = pattern + "|" + regexp.QuoteMeta(crudUpdateFallbackSQL(g.tableMap, q))
+	}
+	// CRUD の Create は /*VALUES*/ を指定されたプロパティの ("column", ...) VALUES (?1, ...) に置き換え、指定されなかった場合は DEFAULT VALUES にする
+	if strings.Contains(sql, "/*VALUES*/") {
+		var names []string
+		for _, c := range q.GetColumns() {
+			names = append(names, regexp.QuoteMeta(quoteIdent(c.GetName())))
+		}
+		name := "(?:" + strings.Join(names, "|") + ")"
+		values := `\(` + name + "(?:, " + name + `)*\) VALUES \(\?[0-9]+(?:, \?[0-9]+)*\)`
+		pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta("/*VALUES*/"), "(?:"+values+"|DEFAULT VALUES)")
+	}
+	if pattern == regexp.QuoteMeta(sql) {
+		return ""
+	}
+	return "^(?:" + pattern + ")$"
+}
+
+// sqlHash は SQL の sha256 を返す
+func sqlHash(sql string) string {
+	sum := sha256.Sum256([]byte(sql))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// marshalManifest は queries.json の内容を返す
+// SQL の比較演算子が読みにくくならないように HTML のエスケープはしない
+func marshalManifest(queries []manifestQuery) ([]byte, error) {
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	e.SetIndent("", "  ")
+	if err := e.Encode(map[string]any{"queries": queries}); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
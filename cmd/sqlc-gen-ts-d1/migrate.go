@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// migrationWarning はマイグレーションで確認が必要な変更
+type migrationWarning struct {
+	// destructive はデータや制約が失われる変更かどうかで、-allow-destructive を指定しない場合はファイルを書き出さない
+	destructive bool
+	message     string
+}
+
+// diffSnapshots は from から to にスキーマを変更する SQL 文と確認が必要な変更を返す
+// SQLite のテーブル名とカラム名は大文字小文字を区別しないので区別せずに比較する
+// 既存の行があると必ず失敗するマイグレーションになる場合はエラーを返す
+func diffSnapshots(from, to *catalogSnapshot) ([]string, []migrationWarning, error) {
+	var stmts []string
+	var warnings []migrationWarning
+	var errs []error
+	// deferForeignKeys はテーブルの作り直しや削除があるかどうか
+	deferForeignKeys := false
+	// tableNames は作り直す際の一時的なテーブルの名前が既存のテーブルと重ならないように使われている名前を記録する
+	tableNames := map[string]bool{}
+	fromTables := map[string]*snapshotTable{}
+	for i := range from.Tables {
+		fromTables[strings.ToLower(from.Tables[i].Name)] = &from.Tables[i]
+		tableNames[strings.ToLower(from.Tables[i].Name)] = true
+	}
+	for _, t := range to.Tables {
+		tableNames[strings.ToLower(t.Name)] = true
+	}
+	toTables := map[string]bool{}
+	for i := range to.Tables {
+		t := &to.Tables[i]
+		toTables[strings.ToLower(t.Name)] = true
+		ft, ok := fromTables[strings.ToLower(t.Name)]
+		if !ok {
+			stmts = append(stmts, t.createSQL(t.Name, false))
+			continue
+		}
+		s, w, rebuild, err := diffTable(ft, t, tableNames)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		stmts = append(stmts, s...)
+		deferForeignKeys = deferForeignKeys || rebuild
+		warnings = append(warnings, w...)
+	}
+	for _, t := range from.Tables {
+		if !toTables[strings.ToLower(t.Name)] {
+			stmts = append(stmts, "DROP TABLE "+quoteIdent(t.Name))
+			deferForeignKeys = true
+			warnings = append(warnings, migrationWarning{true, fmt.Sprintf("table %s is dropped", t.Name)})
+		}
+	}
+	if deferForeignKeys {
+		// D1 のマイグレーションでは foreign_keys を無効にできないので、外部キーの検査をトランザクションの終わりまで遅らせる
+		stmts = append([]string{"PRAGMA defer_foreign_keys = on"}, stmts...)
+		stmts = append(stmts, "PRAGMA defer_foreign_keys = off")
+	}
+	return stmts, warnings, errors.Join(errs...)
+}
+
+// diffTable は同じテーブルのカラムの違いから SQL 文と、テーブルを作り直すかどうかを返す
+// カラムの追加だけであれば ADD COLUMN を使い、それ以外の変更は SQLite の ALTER TABLE でできないためテーブルを作り直す
+// tableNames は小文字にした使われているテーブルの名前で、作り直す際の一時的なテーブルの名前を追加する
+// https://www.sqlite.org/lang_altertable.html#otheralter
+func diffTable(from, to *snapshotTable, tableNames map[string]bool) ([]string, []migrationWarning, bool, error) {
+	var warnings []migrationWarning
+	warn := func(destructive bool, format string, args ...any) {
+		warnings = append(warnings, migrationWarning{destructive, fmt.Sprintf(format, args...)})
+	}
+	fromColumns := map[string]expectedColumn{}
+	for _, c := range from.Columns {
+		fromColumns[strings.ToLower(c.Name)] = c
+	}
+	toColumns := map[string]bool{}
+	pk := map[string]bool{}
+	for _, c := range to.PrimaryKey {
+		pk[strings.ToLower(c)] = true
+	}
+
+	rebuild := !strings.EqualFold(strings.Join(from.PrimaryKey, ","), strings.Join(to.PrimaryKey, ","))
+	var added, common []expectedColumn
+	for _, c := range to.Columns {
+		toColumns[strings.ToLower(c.Name)] = true
+		fc, ok := fromColumns[strings.ToLower(c.Name)]
+		if !ok {
+			added = append(added, c)
+			// 主キーのカラムは ADD COLUMN で追加できない
+			rebuild = rebuild || pk[strings.ToLower(c.Name)]
+			continue
+		}
+		common = append(common, fc)
+		if !strings.EqualFold(fc.Type, c.Type) {
+			rebuild = true
+			warn(false, "column %s.%s changes type from %q to %q; existing values are converted by the new type affinity", to.Name, c.Name, fc.Type, c.Type)
+		}
+		if fc.NotNull != c.NotNull {
+			rebuild = true
+			if c.NotNull && !to.isRowidAlias(c) {
+				warn(false, "column %s.%s becomes NOT NULL; the migration fails if it has NULL values", to.Name, c.Name)
+			}
+		}
+	}
+	for _, c := range from.Columns {
+		if !toColumns[strings.ToLower(c.Name)] {
+			rebuild = true
+			if len(added) > 0 {
+				warn(true, "column %s.%s is dropped; if it was renamed, replace the statements with ALTER TABLE ... RENAME COLUMN", from.Name, c.Name)
+			} else {
+				warn(true, "column %s.%s is dropped", from.Name, c.Name)
+			}
+		}
+	}
+
+	// スナップショットには DEFAULT が含まれないので、追加する NOT NULL のカラムには既存の行の値を決められない
+	// ADD COLUMN は SQLite が拒否し、作り直す場合は既存の行のコピーが失敗するので書き出さない
+	var errs []error
+	for _, c := range added {
+		if c.NotNull && !to.isRowidAlias(c) {
+			errs = append(errs, fmt.Errorf("column %s.%s is added as NOT NULL without DEFAULT; add it as nullable or write the migration by hand", to.Name, c.Name))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, warnings, false, errors.Join(errs...)
+	}
+
+	table := quoteIdent(to.Name)
+	if !rebuild {
+		var stmts []string
+		for _, c := range added {
+			stmts = append(stmts, "ALTER TABLE "+table+" ADD COLUMN "+to.columnSQL(c))
+		}
+		return stmts, warnings, false, nil
+	}
+
+	warn(true, "table %s is rebuilt; DEFAULT, UNIQUE, CHECK and FOREIGN KEY constraints, indexes and triggers that are not in the snapshot are lost", to.Name)
+	tmp := "new_" + to.Name
+	for i := 2; tableNames[strings.ToLower(tmp)]; i++ {
+		tmp = fmt.Sprintf("new_%s_%d", to.Name, i)
+	}
+	tableNames[strings.ToLower(tmp)] = true
+	stmts := []string{to.createSQL(tmp, false)}
+	if len(common) > 0 {
+		var columns []string
+		for _, c := range common {
+			columns = append(columns, quoteIdent(c.Name))
+		}
+		stmts = append(stmts, fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", quoteIdent(tmp), strings.Join(columns, ", "), strings.Join(columns, ", "), table))
+	}
+	stmts = append(stmts,
+		"DROP TABLE "+table,
+		"ALTER TABLE "+quoteIdent(tmp)+" RENAME TO "+table,
+	)
+	return stmts, warnings, true, nil
+}
+
+// renderMigration はマイグレーションのファイルの内容を返す
+func renderMigration(from, to *catalogSnapshot, stmts []string, warnings []migrationWarning) []byte {
+	var b bytes.Buffer
+	b.WriteString("-- Generated by sqlc-gen-ts-d1 diff.\n")
+	fmt.Fprintf(&b, "-- from: %s\n", from.Fingerprint)
+	fmt.Fprintf(&b, "-- to:   %s\n", to.Fingerprint)
+	for _, w := range warnings {
+		fmt.Fprintf(&b, "-- %s\n", w)
+	}
+	for _, s := range stmts {
+		fmt.Fprintf(&b, "\n%s;\n", s)
+	}
+	return b.Bytes()
+}
+
+// String は stderr とマイグレーションのコメントに書き出す文字列を返す
+func (w migrationWarning) String() string {
+	if w.destructive {
+		return "DESTRUCTIVE: " + w.message
+	}
+	return "WARNING: " + w.message
+}
+
+// nextMigrationPath は wrangler の migrations のディレクトリに次に書き出すファイルのパスを返す
+// ファイル名は 0001_name.sql の形式で、番号はディレクトリにあるファイルの最大の番号の次になる
+func nextMigrationPath(dir, name string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	last := 0
+	for _, e := range entries {
+		n, _, ok := strings.Cut(e.Name(), "_")
+		if !ok || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		if i, err := strconv.Atoi(n); err == nil && i > last {
+			last = i
+		}
+	}
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	name = strings.Trim(b.String(), "_")
+	if name == "" {
+		name = "migration"
+	}
+	return filepath.Join(dir, fmt.Sprintf("%04d_%s.sql", last+1, name)), nil
+}
+
+// runDiff は2つの catalog.snapshot.json を比較してマイグレーションのファイルを書き出す
+//
+//	sqlc-gen-ts-d1 diff [-dir migrations] [-name schema] [-allow-destructive] <old.json> <new.json>
+func runDiff(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dir := fs.String("dir", "migrations", "directory of the wrangler migrations")
+	name := fs.String("name", "schema", "name of the migration")
+	allowDestructive := fs.Bool("allow-destructive", false, "write the migration even if it drops tables, columns or constraints")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: sqlc-gen-ts-d1 diff [flags] <old catalog.snapshot.json> <new catalog.snapshot.json>")
+	}
+	var snapshots [2]*catalogSnapshot
+	for i, path := range fs.Args() {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if snapshots[i], err = readSnapshot(b); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	stmts, warnings, err := diffSnapshots(snapshots[0], snapshots[1])
+	if err != nil {
+		return fmt.Errorf("the migration cannot be generated:\n%w", err)
+	}
+	if len(stmts) == 0 {
+		fmt.Fprintln(stderr, "no changes")
+		return nil
+	}
+	destructive := false
+	for _, w := range warnings {
+		fmt.Fprintln(stderr, w)
+		destructive = destructive || w.destructive
+	}
+	if destructive && !*allowDestructive {
+		return fmt.Errorf("the migration has destructive changes; review them and re-run with -allow-destructive")
+	}
+
+	path, err := nextMigrationPath(*dir, *name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, renderMigration(snapshots[0], snapshots[1], stmts, warnings), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, path)
+	return nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func snapshotOf(tables ...snapshotTable) *catalogSnapshot {
+	return &catalogSnapshot{Version: snapshotVersion, Tables: tables}
+}
+
+func snapTable(name string, pk []string, columns ...expectedColumn) snapshotTable {
+	return snapshotTable{Name: name, Columns: columns, PrimaryKey: pk}
+}
+
+func snapColumn(name, typ string, notNull bool) expectedColumn {
+	return expectedColumn{Name: name, Type: typ, NotNull: notNull}
+}
+
+var accountV1 = snapTable("account", []string{"pk"},
+	snapColumn("pk", "INTEGER", true),
+	snapColumn("id", "TEXT", true),
+	snapColumn("email", "TEXT", false),
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	for _, tt := range []struct {
+		name            string
+		from, to        *catalogSnapshot
+		want            []string
+		wantDestructive bool
+		wantErr         string
+	}{
+		{
+			name: "no changes",
+			from: snapshotOf(accountV1),
+			to:   snapshotOf(accountV1),
+		},
+		{
+			name: "add column",
+			from: snapshotOf(accountV1),
+			to: snapshotOf(snapTable("account", []string{"pk"},
+				snapColumn("pk", "INTEGER", true),
+				snapColumn("id", "TEXT", true),
+				snapColumn("email", "TEXT", false),
+				snapColumn("bio", "TEXT", false),
+			)),
+			want: []string{"ALTER TABLE account ADD COLUMN bio TEXT"},
+		},
+		{
+			name: "add NOT NULL column",
+			from: snapshotOf(accountV1),
+			to: snapshotOf(snapTable("account", []string{"pk"},
+				snapColumn("pk", "INTEGER", true),
+				snapColumn("id", "TEXT", true),
+				snapColumn("email", "TEXT", false),
+				snapColumn("name", "TEXT", true),
+			)),
+			wantErr: "column account.name is added as NOT NULL without DEFAULT",
+		},
+		{
+			name: "new table",
+			from: snapshotOf(accountV1),
+			to:   snapshotOf(accountV1, snapTable("post", nil, snapColumn("id", "TEXT", true), snapColumn("order", "INTEGER", false))),
+			want: []string{"CREATE TABLE post (\n  id TEXT NOT NULL,\n  \"order\" INTEGER\n)"},
+		},
+		{
+			name: "change type",
+			from: snapshotOf(accountV1),
+			to: snapshotOf(snapTable("account", []string{"pk"},
+				snapColumn("pk", "INTEGER", true),
+				snapColumn("id", "INTEGER", true),
+				snapColumn("email", "TEXT", false),
+			)),
+			want: []string{
+				"PRAGMA defer_foreign_keys = on",
+				"CREATE TABLE new_account (\n  pk INTEGER,\n  id INTEGER NOT NULL,\n  email TEXT,\n  PRIMARY KEY (pk)\n)",
+				"INSERT INTO new_account (pk, id, email) SELECT pk, id, email FROM account",
+				"DROP TABLE account",
+				"ALTER TABLE new_account RENAME TO account",
+				"PRAGMA defer_foreign_keys = off",
+			},
+			wantDestructive: true,
+		},
+		{
+			name: "drop table",
+			from: snapshotOf(accountV1, snapTable("post", nil, snapColumn("id", "TEXT", true))),
+			to:   snapshotOf(accountV1),
+			want: []string{
+				"PRAGMA defer_foreign_keys = on",
+				"DROP TABLE post",
+				"PRAGMA defer_foreign_keys = off",
+			},
+			wantDestructive: true,
+		},
+		{
+			name: "case-insensitive names",
+			from: snapshotOf(snapTable("Account", []string{"PK"},
+				snapColumn("PK", "integer", true),
+				snapColumn("Id", "text", true),
+				snapColumn("EMAIL", "text", false),
+			)),
+			to: snapshotOf(accountV1),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stmts, warnings, err := diffSnapshots(tt.from, tt.to)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("diffSnapshots() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(stmts, tt.want) {
+				t.Errorf("diffSnapshots() =\n%q\nwant\n%q", stmts, tt.want)
+			}
+			destructive := false
+			for _, w := range warnings {
+				destructive = destructive || w.destructive
+			}
+			if destructive != tt.wantDestructive {
+				t.Errorf("diffSnapshots() destructive = %v, want %v: %v", destructive, tt.wantDestructive, warnings)
+			}
+		})
+	}
+}
+
+func TestDiffTable(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		to          snapshotTable
+		tableNames  []string
+		wantRebuild bool
+		wantTmp     string
+	}{
+		{
+			name: "add column",
+			to: snapTable("account", []string{"pk"},
+				snapColumn("pk", "INTEGER", true),
+				snapColumn("id", "TEXT", true),
+				snapColumn("email", "TEXT", false),
+				snapColumn("bio", "TEXT", false),
+			),
+		},
+		{
+			name: "change type",
+			to: snapTable("account", []string{"pk"},
+				snapColumn("pk", "INTEGER", true),
+				snapColumn("id", "BLOB", true),
+				snapColumn("email", "TEXT", false),
+			),
+			wantRebuild: true,
+			wantTmp:     "new_account",
+		},
+		{
+			name: "become NOT NULL",
+			to: snapTable("account", []string{"pk"},
+				snapColumn("pk", "INTEGER", true),
+				snapColumn("id", "TEXT", true),
+				snapColumn("email", "TEXT", true),
+			),
+			wantRebuild: true,
+			wantTmp:     "new_account",
+		},
+		{
+			name: "change primary key",
+			to: snapTable("account", []string{"id"},
+				snapColumn("pk", "INTEGER", true),
+				snapColumn("id", "TEXT", true),
+				snapColumn("email", "TEXT", false),
+			),
+			wantRebuild: true,
+			wantTmp:     "new_account",
+		},
+		{
+			name: "drop column",
+			to: snapTable("account", []string{"pk"},
+				snapColumn("pk", "INTEGER", true),
+				snapColumn("id", "TEXT", true),
+			),
+			wantRebuild: true,
+			wantTmp:     "new_account",
+		},
+		{
+			name: "temporary table exists",
+			to: snapTable("account", []string{"pk"},
+				snapColumn("pk", "INTEGER", true),
+				snapColumn("id", "TEXT", true),
+			),
+			tableNames:  []string{"account", "New_Account", "new_account_2"},
+			wantRebuild: true,
+			wantTmp:     "new_account_3",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tableNames := map[string]bool{}
+			for _, name := range tt.tableNames {
+				tableNames[strings.ToLower(name)] = true
+			}
+			from := accountV1
+			stmts, _, rebuild, err := diffTable(&from, &tt.to, tableNames)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rebuild != tt.wantRebuild {
+				t.Fatalf("diffTable() rebuild = %v, want %v: %q", rebuild, tt.wantRebuild, stmts)
+			}
+			if !rebuild {
+				return
+			}
+			if want := "CREATE TABLE " + tt.wantTmp + " ("; !strings.HasPrefix(stmts[0], want) {
+				t.Errorf("diffTable() creates %q, want %q", stmts[0], want)
+			}
+			if want := "ALTER TABLE " + tt.wantTmp + " RENAME TO account"; stmts[len(stmts)-1] != want {
+				t.Errorf("diffTable() renames %q, want %q", stmts[len(stmts)-1], want)
+			}
+			if !tableNames[tt.wantTmp] {
+				t.Errorf("diffTable() does not reserve %s", tt.wantTmp)
+			}
+		})
+	}
+}
+
+func TestNextMigrationPath(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		files []string
+		arg   string
+		want  string
+	}{
+		{name: "empty", arg: "schema", want: "0001_schema.sql"},
+		{name: "existing", files: []string{"0001_init.sql", "0003_add_email.sql", "README.md", "0010_notes.txt", "x_0020.sql"}, arg: "schema", want: "0004_schema.sql"},
+		{name: "name", files: []string{"0001_init.sql"}, arg: "Add Email!", want: "0002_add_email.sql"},
+		{name: "empty name", arg: "---", want: "0001_migration.sql"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, f), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := nextMigrationPath(dir, tt.arg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(dir, tt.want); got != want {
+				t.Errorf("nextMigrationPath() = %q, want %q", got, want)
+			}
+		})
+	}
+
+	got, err := nextMigrationPath(filepath.Join(t.TempDir(), "missing"), "schema")
+	if err != nil || filepath.Base(got) != "0001_schema.sql" {
+		t.Errorf("nextMigrationPath() for a missing directory = %q, %v", got, err)
+	}
+}
+
+func TestRunDiffDestructive(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i, s := range []*catalogSnapshot{snapshotOf(accountV1, snapTable("post", nil, snapColumn("id", "TEXT", true))), snapshotOf(accountV1)} {
+		b, err := marshalSnapshot(*s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, []string{"old.json", "new.json"}[i])
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	migrations := filepath.Join(dir, "migrations")
+
+	var stdout, stderr bytes.Buffer
+	if err := runDiff(append([]string{"-dir", migrations}, paths...), &stdout, &stderr); err == nil {
+		t.Fatal("runDiff() without -allow-destructive succeeded")
+	}
+	if !strings.Contains(stderr.String(), "DESTRUCTIVE: table post is dropped") {
+		t.Errorf("runDiff() stderr = %q", stderr.String())
+	}
+	if _, err := os.Stat(migrations); !os.IsNotExist(err) {
+		t.Errorf("runDiff() without -allow-destructive wrote %s: %v", migrations, err)
+	}
+
+	stdout.Reset()
+	if err := runDiff(append([]string{"-dir", migrations, "-allow-destructive"}, paths...), &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "\nDROP TABLE post;\n") {
+		t.Errorf("runDiff() wrote:\n%s", b)
+	}
+}
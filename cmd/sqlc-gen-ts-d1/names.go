@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// nameScope は同じスコープに書き出される名前とその生成元を記録して衝突を検出する
+// スコープはモジュールのトップレベルや型のプロパティなど、名前が重複すると TypeScript のエラーになる範囲
+type nameScope struct {
+	desc  string
+	names map[string]string
+	errs  *[]error
+}
+
+// declare は名前を登録し、既に別の生成元から同じ名前が登録されている場合はエラーを記録して false を返す
+func (s *nameScope) declare(name, source string) bool {
+	if prev, ok := s.names[name]; ok {
+		*s.errs = append(*s.errs, fmt.Errorf("%s: %q is generated by both %s and %s", s.desc, name, prev, source))
+		return false
+	}
+	s.names[name] = source
+	return true
+}
+
+// nameChecker は生成するコードの名前の衝突をまとめて検出する
+type nameChecker struct {
+	errs []error
+}
+
+func (c *nameChecker) scope(desc string) *nameScope {
+	return &nameScope{desc: desc, names: map[string]string{}, errs: &c.errs}
+}
+
+func querySource(q *plugin.Query) string {
+	if q.GetFilename() == "" {
+		return "query " + q.GetName()
+	}
+	return fmt.Sprintf("query %s (%s)", q.GetName(), q.GetFilename())
+}
+
+func tableSource(t *plugin.Identifier) string {
+	return "table " + t.GetName()
+}
+
+func crudSource(t *plugin.Identifier) string {
+	return "crud of table " + t.GetName()
+}
+
+func columnSource(c *plugin.Column) string {
+	return "column " + c.GetName()
+}
+
+func paramSource(p *plugin.Parameter) string {
+	return fmt.Sprintf("parameter %s (?%d)", p.GetColumn().GetName(), p.GetNumber())
+}
+
+func embedColumnSource(e *plugin.Identifier, c *plugin.Column) string {
+	return fmt.Sprintf("sqlc.embed(%s).%s", e.GetName(), c.GetName())
+}
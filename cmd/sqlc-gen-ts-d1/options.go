@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Options はプラグインのオプション
+//
+// オプションは JSON のオブジェクトかカンマ区切りの key=value 形式の文字列で指定する
+// key=value 形式の場合は値を文字列として解釈し、Bool, List, Map はそれぞれの文字列の形式で指定する
+// json タグはオプション名、doc タグと enum タグ、default タグは options-schema で出力するスキーマに使う
+type Options struct {
+	WorkersTypes   string `json:"workers-types" default:"2022-11-30" doc:"@cloudflare/workers-types の v4 の import する細かいバージョン"`
+	WorkersTypesV3 Bool   `json:"workers-types-v3" doc:"@cloudflare/workers-types の v3 のために types-source=global と results-optional を指定する"`
+
+	TypesSource     string `json:"types-source" enum:"package subpath global module" doc:"D1 の型の取得元。デフォルトは subpath で、workers-types-v3 の場合は global"`
+	TypesModule     string `json:"types-module" doc:"types-source=module の場合に D1 の型を import するモジュール"`
+	TypeNames       Map    `json:"type-names" doc:"D1Database, D1PreparedStatement, D1Result の代わりに使う型の名前"`
+	ResultsOptional Bool   `json:"results-optional" doc:"D1Result の results が undefined になりうる古い D1 に対応する"`
+
+	PrimaryKeys List `json:"primary-keys" doc:"テーブルの主キーを table.column 形式で指定する。同じテーブルのカラムを複数指定すると複合主キーになる"`
+
+	Scalar        Bool   `json:"scalar" doc:"sqlc.embed ではないカラムを1つだけ返す :one と :many のクエリの結果を値で返す"`
+	ScalarQueries List   `json:"scalar-queries" doc:"結果を値で返すクエリの名前"`
+	ManyReturn    string `json:"many-return" enum:"result array" default:"result" doc:":many のクエリの結果を D1Result で返すか配列で返すか"`
+
+	PropertyNaming              string `json:"property-naming" enum:"camel snake preserve" default:"camel" doc:"カラム名からプロパティ名への変換方法"`
+	SingularizeTableNames       Bool   `json:"singularize-table-names" doc:"テーブル名を単数形にしてモデルの型名にする"`
+	InflectionExcludeTableNames List   `json:"inflection-exclude-table-names" doc:"単数形に変換しないテーブル名"`
+	InflectionOverrides         Map    `json:"inflection-overrides" doc:"テーブル名から単数形への対応"`
+
+	EmitQueryMeta       Bool `json:"emit-query-meta" doc:"クエリごとにデータを変更するかどうかや読み書きするテーブルなどの情報を QueryMeta の定数として出力する"`
+	EmitErrors          Bool `json:"emit-errors" doc:"制約違反のエラーを型付きのエラーに変換する errors.ts を出力する"`
+	EmitManifest        Bool `json:"emit-manifest" doc:"クエリの名前と SQL、パラメータ、結果のカラム、テーブル、SQL のハッシュを queries.json に出力する"`
+	EmitExplain         Bool `json:"emit-explain" doc:"クエリごとに EXPLAIN QUERY PLAN を実行する関数と、大きなテーブルの SCAN を報告する explainAll を出力する"`
+	EmitVerify          Bool `json:"emit-verify" doc:"実際のデータベースのスキーマとスキーマの定義の違いを調べる verifySchema を verify.ts に出力する"`
+	EmitSchema          Bool `json:"emit-schema" doc:"スキーマの定義から作り直した CREATE TABLE 文と、それを実行する applySchema を schema.ts に出力する"`
+	EmitCatalogSnapshot Bool `json:"emit-catalog-snapshot" doc:"テーブルとカラム、主キーを catalog.snapshot.json に出力する。diff サブコマンドで2つのスナップショットからマイグレーションを作る"`
+	EmitHooks           Bool `json:"emit-hooks" doc:"クエリの実行の前後に呼び出されるフックと、フックを指定する createQueries を出力する"`
+	EmitLoaders         Bool `json:"emit-loaders" doc:"sqlc.slice のパラメータだけを持つ :many のクエリの要素ごとの呼び出しをまとめて実行する Loader を出力する"`
+
+	Crud       Bool `json:"crud" doc:"全てのテーブルに対して CRUD のクエリを生成する"`
+	CrudTables List `json:"crud-tables" doc:"CRUD のクエリを生成するテーブル"`
+}
+
+// Bool は真偽値のオプション
+// JSON の true, false の他に文字列の "1", "0", "true", "false" を受け付ける
+type Bool bool
+
+func (b *Bool) UnmarshalJSON(data []byte) error {
+	var v bool
+	if err := json.Unmarshal(data, &v); err == nil {
+		*b = Bool(v)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("must be a boolean or \"1\", \"0\": %s", data)
+	}
+	switch s {
+	case "1", "true":
+		*b = true
+	case "0", "false", "":
+		*b = false
+	default:
+		return fmt.Errorf("must be a boolean or \"1\", \"0\": %q", s)
+	}
+	return nil
+}
+
+func (Bool) jsonSchema() map[string]any {
+	return map[string]any{
+		"anyOf": []any{
+			map[string]any{"type": "boolean"},
+			map[string]any{"type": "string", "enum": []string{"1", "0", "true", "false"}},
+		},
+		"default": false,
+	}
+}
+
+// List は文字列のリストのオプション
+// JSON の文字列の配列の他に空白区切りの文字列を受け付ける
+type List []string
+
+func (l *List) UnmarshalJSON(data []byte) error {
+	var v []string
+	if err := json.Unmarshal(data, &v); err == nil {
+		*l = v
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("must be an array of strings or a space-separated string: %s", data)
+	}
+	*l = strings.Fields(s)
+	return nil
+}
+
+func (List) jsonSchema() map[string]any {
+	return map[string]any{
+		"anyOf": []any{
+			map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			map[string]any{"type": "string", "description": "空白区切りのリスト"},
+		},
+	}
+}
+
+// Map は文字列から文字列への対応のオプション
+// JSON のオブジェクトの他に key:value を空白区切りで並べた文字列を受け付ける
+type Map map[string]string
+
+func (m *Map) UnmarshalJSON(data []byte) error {
+	var v map[string]string
+	if err := json.Unmarshal(data, &v); err == nil {
+		*m = v
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("must be an object of strings or a space-separated string of key:value: %s", data)
+	}
+	v = map[string]string{}
+	for _, kv := range strings.Fields(s) {
+		k, x, ok := strings.Cut(kv, ":")
+		if !ok || k == "" || x == "" {
+			return fmt.Errorf("invalid entry %q: must be key:value", kv)
+		}
+		v[k] = x
+	}
+	*m = v
+	return nil
+}
+
+func (Map) jsonSchema() map[string]any {
+	return map[string]any{
+		"anyOf": []any{
+			map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}},
+			map[string]any{"type": "string", "description": "key:value を空白区切りで並べた文字列"},
+		},
+	}
+}
+
+// parseOption はプラグインのオプションを解析する
+// 不明なオプションや値の誤りはオプション名を含むエラーにする
+func parseOption(opt []byte) (*Options, error) {
+	raw := map[string]json.RawMessage{}
+	if bytes.HasPrefix(opt, []byte("{")) {
+		if err := json.Unmarshal(opt, &raw); err != nil {
+			return nil, fmt.Errorf("unmarshal: %w", err)
+		}
+	} else if len(opt) > 0 {
+		// sqlc は options に文字列を指定した場合 JSON の文字列として渡す
+		var s string
+		if err := json.Unmarshal(opt, &s); err != nil {
+			return nil, fmt.Errorf("unmarshal: %w", err)
+		}
+		for _, kv := range strings.Split(s, ",") {
+			if strings.TrimSpace(kv) == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid option %q: must be key=value", kv)
+			}
+			b, _ := json.Marshal(v)
+			raw[strings.TrimSpace(k)] = b
+		}
+	}
+
+	o := &Options{}
+	v := reflect.ValueOf(o).Elem()
+	fields := optionFields()
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		f, ok := fields[k]
+		if !ok {
+			return nil, fmt.Errorf("unknown option %q", k)
+		}
+		fv := v.Field(f.Index[0])
+		if err := json.Unmarshal(raw[k], fv.Addr().Interface()); err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		if enum := f.Tag.Get("enum"); enum != "" && !contains(strings.Fields(enum), fv.String()) {
+			return nil, fmt.Errorf("%s: must be one of %s: %q", k, strings.Join(strings.Fields(enum), ", "), fv.String())
+		}
+	}
+	// 指定されなかったオプションはデフォルト値にする
+	for k, f := range fields {
+		if _, ok := raw[k]; !ok && f.Tag.Get("default") != "" {
+			v.Field(f.Index[0]).SetString(f.Tag.Get("default"))
+		}
+	}
+	return o, nil
+}
+
+// optionFields はオプション名から Options のフィールドへの対応を返す
+func optionFields() map[string]reflect.StructField {
+	t := reflect.TypeOf(Options{})
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fields[f.Tag.Get("json")] = f
+	}
+	return fields
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// optionsSchema はエディタの補完に使えるオプションの JSON Schema を返す
+func optionsSchema() map[string]any {
+	properties := map[string]any{}
+	for name, f := range optionFields() {
+		var p map[string]any
+		if s, ok := reflect.Zero(f.Type).Interface().(interface{ jsonSchema() map[string]any }); ok {
+			p = s.jsonSchema()
+		} else {
+			p = map[string]any{"type": "string"}
+		}
+		if enum := f.Tag.Get("enum"); enum != "" {
+			p["enum"] = strings.Fields(enum)
+		}
+		if d := f.Tag.Get("default"); d != "" {
+			p["default"] = d
+		}
+		p["description"] = f.Tag.Get("doc")
+		properties[name] = p
+	}
+	return map[string]any{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"title":                "sqlc-gen-ts-d1 options",
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
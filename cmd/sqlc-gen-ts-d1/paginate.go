@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// paginateAnnotation は @paginate アノテーションの設定
+// limit, offset, cursor はパラメータのプロパティ名
+type paginateAnnotation struct {
+	// keyset が true の場合は cursor より後の行を、false の場合は offset から後の行を取得する
+	keyset bool
+	// key は keyset の場合に次のページの cursor にする結果のカラム
+	key    *plugin.Column
+	limit  string
+	offset string
+	cursor string
+}
+
+// parsePaginate は @paginate アノテーションの引数を読み取る
+//
+//	@paginate offset [limit=<パラメータ名>] [offset=<パラメータ名>]
+//	@paginate keyset=<カラム名> [limit=<パラメータ名>] [cursor=<パラメータ名>]
+func parsePaginate(q *plugin.Query, arg string) (*paginateAnnotation, error) {
+	if q.GetCmd() != ":many" {
+		return nil, fmt.Errorf("query must be :many")
+	}
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("must be `offset` or `keyset=<column>`")
+	}
+	p := &paginateAnnotation{}
+	limit, offset, cursor := "limit", "offset", "after"
+	mode, key, _ := strings.Cut(fields[0], "=")
+	switch mode {
+	case "offset":
+	case "keyset":
+		for _, c := range q.GetColumns() {
+			if c.GetEmbedTable().GetName() == "" && (c.GetName() == key || naming.toPropertyName(c) == key) {
+				p.key = c
+			}
+		}
+		if p.key == nil {
+			return nil, fmt.Errorf("column not found: %q", key)
+		}
+		p.keyset = true
+	default:
+		return nil, fmt.Errorf("must be `offset` or `keyset=<column>`: %q", fields[0])
+	}
+	for _, kv := range fields[1:] {
+		k, v, _ := strings.Cut(kv, "=")
+		switch {
+		case k == "limit":
+			limit = v
+		case k == "offset" && !p.keyset:
+			offset = v
+		case k == "cursor" && p.keyset:
+			cursor = v
+		default:
+			return nil, fmt.Errorf("unknown option: %q", kv)
+		}
+	}
+
+	if p.limit = findParamProperty(q, limit); p.limit == "" {
+		return nil, fmt.Errorf("parameter not found: %s", limit)
+	}
+	if p.keyset {
+		if p.cursor = findParamProperty(q, cursor); p.cursor == "" {
+			return nil, fmt.Errorf("parameter not found: %s", cursor)
+		}
+	} else {
+		if p.offset = findParamProperty(q, offset); p.offset == "" {
+			return nil, fmt.Errorf("parameter not found: %s", offset)
+		}
+	}
+	return p, nil
+}
+
+// writePaginate はクエリを pageSize 件ずつ呼び出してページごとの結果を返す async generator を書き出す
+func (g *queryWriter) writePaginate(w *bytes.Buffer, q *plugin.Query) {
+	p := g.annotations(q).paginate
+	if p == nil {
+		return
+	}
+	g.requirePaginate = true
+
+	funcName := naming.toPaginateName(q)
+	paramsType := naming.toParamsTypeName(q)
+	rowType := naming.toQueryRowTypeName(q)
+	if c := g.scalarColumn(q); c != nil {
+		rowType = g.tsTypeMap.toTsType(c)
+		if strings.Contains(rowType, " | ") {
+			rowType = "(" + rowType + ")"
+		}
+	}
+
+	// limit と offset はページごとに指定するので引数から除く
+	omit := []string{jsQuote(p.limit)}
+	if !p.keyset {
+		omit = append(omit, jsQuote(p.offset))
+	}
+	params := [][2]string{{"d1", "D1Queryable"}}
+	args := "{ "
+	if len(q.GetParams()) > len(omit) {
+		params = append(params, [2]string{"args", "Omit<" + paramsType + ", " + strings.Join(omit, " | ") + ">"})
+		args += "...args, "
+	}
+	params = append(params, [2]string{"options", "PageOptions"})
+	if p.keyset {
+		args += propertyKey(p.cursor) + ": cursor, "
+	} else if p.offset == "offset" {
+		args += "offset, "
+	} else {
+		args += propertyKey(p.offset) + ": offset, "
+	}
+	args += propertyKey(p.limit) + ": size }"
+	if g.emitHooks {
+		args += ", hooks"
+	}
+
+	fmt.Fprintf(w, "/**\n")
+	if p.keyset {
+		fmt.Fprintf(w, " * %s を %s から pageSize 件ずつ呼び出し、ページごとの結果を返す\n", naming.toFunctionName(q), propertyAccess("args", p.cursor))
+		fmt.Fprintf(w, " * 次のページの %s にはページの最後の行の %s を使う\n", p.cursor, naming.toPropertyName(p.key))
+	} else {
+		fmt.Fprintf(w, " * %s を pageSize 件ずつ %s をずらしながら呼び出し、ページごとの結果を返す\n", naming.toFunctionName(q), p.offset)
+	}
+	fmt.Fprintf(w, " */\n")
+	g.module.declare(funcName, querySource(q))
+	fmt.Fprintf(w, "export async function* %s(\n", funcName)
+	g.writeParams(w, funcName, params)
+	fmt.Fprintf(w, "): AsyncGenerator<%s[], void> {\n", rowType)
+	fmt.Fprintf(w, "  const size = pageSize(options);\n")
+	if p.keyset {
+		fmt.Fprintf(w, "  let cursor: %s[%s] = %s;\n", paramsType, jsQuote(p.cursor), propertyAccess("args", p.cursor))
+		fmt.Fprintf(w, "  for (;;) {\n")
+	} else {
+		fmt.Fprintf(w, "  for (let offset = 0; ; offset += size) {\n")
+	}
+	call := naming.toFunctionName(q) + "(d1, " + args + ")"
+	switch {
+	case g.scalarColumn(q) != nil || g.isArrayMany(q):
+		fmt.Fprintf(w, "    const page = await %s;\n", call)
+	case g.resultsOptional:
+		fmt.Fprintf(w, "    const page = (await %s).results ?? [];\n", call)
+	default:
+		fmt.Fprintf(w, "    const page = (await %s).results;\n", call)
+	}
+	fmt.Fprintf(w, "    if (page.length > 0) {\n")
+	fmt.Fprintf(w, "      yield page;\n")
+	fmt.Fprintf(w, "    }\n")
+	fmt.Fprintf(w, "    if (page.length < size) {\n")
+	fmt.Fprintf(w, "      return;\n")
+	fmt.Fprintf(w, "    }\n")
+	if p.keyset {
+		last := "page[page.length - 1]"
+		if g.scalarColumn(q) == nil {
+			last = propertyAccess(last, naming.toPropertyName(p.key))
+		}
+		fmt.Fprintf(w, "    cursor = %s;\n", last)
+	}
+	fmt.Fprintf(w, "  }\n")
+	w.WriteString("}\n")
+
+	w.WriteByte('\n')
+}
+
+// paginateRuntime はページの取得の設定とページを行ごとに返す関数
+const paginateRuntime = `/** ページの取得の設定 */
+export type PageOptions = {
+  /** 1ページの行数。pageSize より少ない行が返された場合は最後のページになる */
+  pageSize: number;
+};
+function pageSize(options: PageOptions): number {
+  // 0 以下の場合は最後のページを判定できず終わらなくなる
+  if (!Number.isInteger(options.pageSize) || options.pageSize <= 0) {
+    throw new RangeError("pageSize must be a positive integer: " + options.pageSize);
+  }
+  return options.pageSize;
+}
+/** ページごとの結果を1行ずつ返す */
+export async function* eachRow<T>(pages: AsyncIterable<T[]>): AsyncGenerator<T, void> {
+  for await (const page of pages) {
+    yield* page;
+  }
+}
+`
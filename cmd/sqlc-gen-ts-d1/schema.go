@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// writeSchema はカタログから作り直した CREATE TABLE 文とそれを実行する applySchema を書き出す
+// カタログにはカラムの名前と型と NOT NULL しか含まれないので、主キーは primary-keys オプションから求め、DEFAULT や UNIQUE などの制約は含まない
+func writeSchema(w *bytes.Buffer, tableMap TableMap, catalog *plugin.Catalog) {
+	w.WriteString("/** スキーマの定義から作り直した CREATE TABLE 文 */\n")
+	w.WriteString("export const schemaStatements: string[] = [\n")
+	for _, t := range buildSnapshot(tableMap, catalog).Tables {
+		fmt.Fprintf(w, "  `%s`,\n", escapeTemplateLiteral(t.createSQL(t.Name, true)))
+	}
+	w.WriteString("];\n")
+	w.WriteString(`/** schemaStatements を1つの batch で実行する。既にあるテーブルは変更しない */
+export function applySchema(d1: Pick<D1Database, "prepare" | "batch">): Promise<D1Result[]> {
+  return d1.batch(schemaStatements.map((sql: string) => d1.prepare(sql)));
+}
+`)
+}
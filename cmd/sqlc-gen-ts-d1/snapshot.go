@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// catalogSnapshot は catalog.snapshot.json に書き出すスキーマの情報
+// diff で2つのスナップショットを比較してマイグレーションを作る
+type catalogSnapshot struct {
+	Version int `json:"version"`
+	// Fingerprint は verify.ts の schemaFingerprint と同じ値
+	Fingerprint string          `json:"fingerprint"`
+	Tables      []snapshotTable `json:"tables"`
+}
+
+// snapshotVersion は catalog.snapshot.json の形式のバージョン
+const snapshotVersion = 1
+
+type snapshotTable struct {
+	Name    string           `json:"name"`
+	Columns []expectedColumn `json:"columns"`
+	// PrimaryKey は primary-keys オプションで指定された主キーのカラム
+	PrimaryKey []string `json:"primaryKey,omitempty"`
+}
+
+// buildSnapshot はカタログのテーブルと primary-keys オプションの主キーからスナップショットを作る
+func buildSnapshot(tableMap TableMap, catalog *plugin.Catalog) catalogSnapshot {
+	expected := buildExpectedSchema(catalog)
+	snapshot := catalogSnapshot{
+		Version:     snapshotVersion,
+		Fingerprint: schemaFingerprint(expected),
+		Tables:      []snapshotTable{},
+	}
+	for _, t := range expected {
+		st := snapshotTable{Name: t.Name, Columns: t.Columns}
+		for _, c := range tableMap.findPrimaryKey(&plugin.Identifier{Name: t.Name}) {
+			st.PrimaryKey = append(st.PrimaryKey, c.GetName())
+		}
+		snapshot.Tables = append(snapshot.Tables, st)
+	}
+	return snapshot
+}
+
+// marshalSnapshot は catalog.snapshot.json の内容を返す
+func marshalSnapshot(snapshot catalogSnapshot) ([]byte, error) {
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	e.SetIndent("", "  ")
+	if err := e.Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// readSnapshot は catalog.snapshot.json を読み込む
+func readSnapshot(b []byte) (*catalogSnapshot, error) {
+	var snapshot catalogSnapshot
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		return nil, err
+	}
+	if snapshot.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version: %d", snapshot.Version)
+	}
+	return &snapshot, nil
+}
+
+// isRowidAlias はカラムが rowid のエイリアス(INTEGER PRIMARY KEY)かどうかを返す
+func (t *snapshotTable) isRowidAlias(c expectedColumn) bool {
+	return len(t.PrimaryKey) == 1 && t.PrimaryKey[0] == c.Name && strings.ToUpper(c.Type) == "INTEGER"
+}
+
+// columnSQL は CREATE TABLE や ADD COLUMN に書くカラムの定義を返す
+func (t *snapshotTable) columnSQL(c expectedColumn) string {
+	def := quoteIdent(c.Name)
+	if c.Type != "" {
+		def += " " + c.Type
+	}
+	// rowid のエイリアスは NULL を挿入すると rowid が採番されるので NOT NULL にしない
+	if c.NotNull && !t.isRowidAlias(c) {
+		def += " NOT NULL"
+	}
+	return def
+}
+
+// createSQL はテーブルを name という名前で作る CREATE TABLE 文を返す
+// スナップショットにはカラムの名前と型と NOT NULL と主キーしか含まれないので、DEFAULT や UNIQUE などの制約は含まない
+func (t *snapshotTable) createSQL(name string, ifNotExists bool) string {
+	var defs []string
+	for _, c := range t.Columns {
+		defs = append(defs, t.columnSQL(c))
+	}
+	if len(t.PrimaryKey) > 0 {
+		var names []string
+		for _, c := range t.PrimaryKey {
+			names = append(names, quoteIdent(c))
+		}
+		defs = append(defs, "PRIMARY KEY ("+strings.Join(names, ", ")+")")
+	}
+	create := "CREATE TABLE "
+	if ifNotExists {
+		create += "IF NOT EXISTS "
+	}
+	return fmt.Sprintf("%s%s (\n  %s\n)", create, quoteIdent(name), strings.Join(defs, ",\n  "))
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"strings"
+)
+
+const (
+	// sqlWord はキーワードやクオートされていない識別子
+	sqlWord = iota
+	// sqlQuotedIdent は "x", `x`, [x] のようにクオートされた識別子
+	sqlQuotedIdent
+	// sqlString は 'x' の文字列リテラル
+	sqlString
+	// sqlSymbol はそれ以外の記号や数値、パラメータ
+	sqlSymbol
+)
+
+type sqlToken struct {
+	kind int
+	// text はクオートされた識別子の場合はクオートを外した名前
+	text string
+}
+
+// isKeyword はトークンが大文字小文字を区別せずに指定されたキーワードと一致するかどうかを返す
+func (t sqlToken) isKeyword(keyword string) bool {
+	return t.kind == sqlWord && strings.EqualFold(t.text, keyword)
+}
+
+// isIdent はトークンが識別子として使われうるかどうかを返す
+func (t sqlToken) isIdent() bool {
+	return t.kind == sqlWord || t.kind == sqlQuotedIdent
+}
+
+// tokenizeSQL は SQLite の SQL を大まかにトークンに分割する
+// コメントは読み飛ばし、文字列やクオートされた識別子の中身は解釈しない
+func tokenizeSQL(s string) []sqlToken {
+	var tokens []sqlToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(s[i:], "--"):
+			if j := strings.IndexByte(s[i:], '\n'); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(s)
+			}
+		case strings.HasPrefix(s[i:], "/*"):
+			if j := strings.Index(s[i+2:], "*/"); j >= 0 {
+				i += j + 4
+			} else {
+				i = len(s)
+			}
+		case c == '\'' || c == '"' || c == '`':
+			// クオートは2つ重ねることでエスケープされる
+			var b strings.Builder
+			j := i + 1
+			for j < len(s) {
+				if s[j] == c {
+					if j+1 < len(s) && s[j+1] == c {
+						b.WriteByte(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[j])
+				j++
+			}
+			kind := sqlQuotedIdent
+			if c == '\'' {
+				kind = sqlString
+			}
+			tokens = append(tokens, sqlToken{kind: kind, text: b.String()})
+			i = j + 1
+		case c == '[':
+			j := strings.IndexByte(s[i:], ']')
+			if j < 0 {
+				j = len(s) - i
+			}
+			tokens = append(tokens, sqlToken{kind: sqlQuotedIdent, text: s[i+1 : i+j]})
+			i += j + 1
+		case isSQLWordByte(c) && !(c >= '0' && c <= '9'):
+			j := i
+			for j < len(s) && isSQLWordByte(s[j]) {
+				j++
+			}
+			tokens = append(tokens, sqlToken{kind: sqlWord, text: s[i:j]})
+			i = j
+		case c == '?' || c == ':' || c == '@' || c == '$' || c >= '0' && c <= '9':
+			// パラメータと数値は記号として扱う
+			j := i + 1
+			for j < len(s) && isSQLWordByte(s[j]) {
+				j++
+			}
+			tokens = append(tokens, sqlToken{kind: sqlSymbol, text: s[i:j]})
+			i = j
+		default:
+			tokens = append(tokens, sqlToken{kind: sqlSymbol, text: s[i : i+1]})
+			i++
+		}
+	}
+	return tokens
+}
+
+func isSQLWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// isReadOnlySQL は SQL がデータを変更しない文かどうかを返す
+// WITH 句は SELECT しか含められないので、WITH の後に INSERT, UPDATE, DELETE, REPLACE があれば書き込みとみなす
+func isReadOnlySQL(s string) bool {
+	tokens := tokenizeSQL(s)
+	if len(tokens) == 0 {
+		return true
+	}
+	first := tokens[0]
+	switch {
+	case first.isKeyword("SELECT"), first.isKeyword("VALUES"), first.isKeyword("EXPLAIN"):
+		return true
+	case first.isKeyword("WITH"):
+		for i, t := range tokens {
+			if t.isKeyword("INSERT") || t.isKeyword("UPDATE") || t.isKeyword("DELETE") {
+				return false
+			}
+			// replace() 関数と区別する
+			if t.isKeyword("REPLACE") && (i+1 >= len(tokens) || tokens[i+1].text != "(") {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// isWriteStatement は SQL が INSERT, UPDATE, DELETE, REPLACE の文かどうかを返す
+// isReadOnlySQL と異なり、確実にデータを変更する文だけを true にする
+func isWriteStatement(s string) bool {
+	tokens := tokenizeSQL(s)
+	if len(tokens) == 0 {
+		return false
+	}
+	first := tokens[0]
+	return first.isKeyword("INSERT") || first.isKeyword("UPDATE") || first.isKeyword("DELETE") || first.isKeyword("REPLACE")
+}
+
+// sqlTableRefs は SQL の中で読み込むテーブルと書き込むテーブルの名前を大まかに返す
+// FROM と JOIN の後のテーブルを読み込み、INTO, UPDATE, DELETE FROM の後のテーブルを書き込みとする
+// テーブル以外の名前も含まれうるので呼び出し側でスキーマのテーブルに絞り込む
+func sqlTableRefs(s string) (reads, writes []string) {
+	tokens := tokenizeSQL(s)
+	// tableName は i から始まるテーブル名とその次の位置を返す。schema.table の場合は table を返す
+	tableName := func(i int) (string, int) {
+		if i >= len(tokens) || !tokens[i].isIdent() {
+			return "", i
+		}
+		if i+2 < len(tokens) && tokens[i+1].text == "." && tokens[i+2].isIdent() {
+			return tokens[i+2].text, i + 3
+		}
+		return tokens[i].text, i + 1
+	}
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.isKeyword("INTO"):
+			if name, _ := tableName(i + 1); name != "" {
+				writes = append(writes, name)
+			}
+		case t.isKeyword("UPDATE"):
+			j := i + 1
+			// UPDATE OR REPLACE t
+			if j < len(tokens) && tokens[j].isKeyword("OR") {
+				j += 2
+			}
+			if name, _ := tableName(j); name != "" {
+				writes = append(writes, name)
+			}
+		case t.isKeyword("FROM") && i > 0 && tokens[i-1].isKeyword("DELETE"):
+			if name, _ := tableName(i + 1); name != "" {
+				writes = append(writes, name)
+			}
+		case t.isKeyword("FROM"), t.isKeyword("JOIN"):
+			// FROM a AS x, b y のようにカンマ区切りで複数のテーブルを指定できる
+			for j := i + 1; ; {
+				name, next := tableName(j)
+				if name == "" {
+					break
+				}
+				reads = append(reads, name)
+				j = next
+				if j < len(tokens) && tokens[j].isKeyword("AS") {
+					j++
+				}
+				if j < len(tokens) && tokens[j].isIdent() && !(tokens[j].kind == sqlWord && sqliteKeywords[strings.ToUpper(tokens[j].text)]) {
+					j++
+				}
+				if j >= len(tokens) || tokens[j].text != "," {
+					break
+				}
+				j++
+			}
+		}
+	}
+	return reads, writes
+}
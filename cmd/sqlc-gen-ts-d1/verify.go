@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/orisano/sqlc-gen-ts-d1/codegen/plugin"
+)
+
+// expectedColumn は verify.ts に書き出すスキーマのカラム
+type expectedColumn struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	NotNull bool   `json:"notNull"`
+}
+
+// expectedTable は verify.ts に書き出すスキーマのテーブル
+type expectedTable struct {
+	Name    string           `json:"name"`
+	Columns []expectedColumn `json:"columns"`
+}
+
+// buildExpectedSchema はカタログのテーブルとカラムを定義の順に返す
+func buildExpectedSchema(catalog *plugin.Catalog) []expectedTable {
+	tables := []expectedTable{}
+	for _, s := range catalog.GetSchemas() {
+		for _, t := range s.GetTables() {
+			et := expectedTable{Name: t.GetRel().GetName(), Columns: []expectedColumn{}}
+			for _, c := range t.GetColumns() {
+				et.Columns = append(et.Columns, expectedColumn{
+					Name:    c.GetName(),
+					Type:    c.GetType().GetName(),
+					NotNull: c.GetNotNull(),
+				})
+			}
+			tables = append(tables, et)
+		}
+	}
+	return tables
+}
+
+// schemaFingerprint はスキーマの sha256 を返す
+// テーブルとカラムの名前、型、NOT NULL が同じであれば生成し直しても変わらない
+func schemaFingerprint(tables []expectedTable) string {
+	b, _ := json.Marshal(tables)
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// writeVerify は実際のデータベースのスキーマとカタログのスキーマを比較する verifySchema を書き出す
+func writeVerify(w *bytes.Buffer, catalog *plugin.Catalog) {
+	tables := buildExpectedSchema(catalog)
+
+	w.WriteString("/** sqlc が読み込んだスキーマのカラム */\n")
+	w.WriteString("export type ExpectedColumn = { name: string; type: string; notNull: boolean };\n")
+	w.WriteString("/** sqlc が読み込んだスキーマのテーブルごとのカラム */\n")
+	w.WriteString("export const expectedSchema: Record<string, ExpectedColumn[]> = {\n")
+	for _, t := range tables {
+		fmt.Fprintf(w, "  %s: [\n", propertyKey(t.Name))
+		for _, c := range t.Columns {
+			fmt.Fprintf(w, "    { name: %s, type: %s, notNull: %t },\n", jsQuote(c.Name), jsQuote(c.Type), c.NotNull)
+		}
+		w.WriteString("  ],\n")
+	}
+	w.WriteString("};\n")
+	w.WriteString("/** expectedSchema の sha256。スキーマが変わった場合だけ変わる */\n")
+	fmt.Fprintf(w, "export const schemaFingerprint = %s;\n", jsQuote(schemaFingerprint(tables)))
+	w.WriteString(verifyRuntime)
+}
+
+// verifyRuntime はスキーマの違いの型と verifySchema
+const verifyRuntime = `/**
+ * 実際のデータベースのスキーマと expectedSchema の違い
+ * missing_column の renamedTo は同じ位置にある expectedSchema にないカラムで、名前が変更された可能性がある
+ */
+export type SchemaProblem =
+  | { kind: "missing_table"; table: string }
+  | { kind: "missing_column"; table: string; column: string; renamedTo: string | null }
+  | { kind: "type_mismatch"; table: string; column: string; expected: string; actual: string }
+  | { kind: "nullability_mismatch"; table: string; column: string; expected: boolean; actual: boolean };
+export type SchemaReport = {
+  /** problems がない場合は true */
+  ok: boolean;
+  /** 生成したコードが想定しているスキーマの schemaFingerprint */
+  fingerprint: string;
+  problems: SchemaProblem[];
+};
+type LiveColumn = {
+  tbl: string;
+  cid: number;
+  name: string;
+  type: string;
+  notnull: number;
+  pk: number;
+};
+// https://www.sqlite.org/datatype3.html#determination_of_column_affinity
+function typeAffinity(type: string): string {
+  const t = type.toUpperCase();
+  if (t.includes("INT")) {
+    return "INTEGER";
+  }
+  if (t.includes("CHAR") || t.includes("CLOB") || t.includes("TEXT")) {
+    return "TEXT";
+  }
+  if (t === "" || t.includes("BLOB")) {
+    return "BLOB";
+  }
+  if (t.includes("REAL") || t.includes("FLOA") || t.includes("DOUB")) {
+    return "REAL";
+  }
+  return "NUMERIC";
+}
+function compareSchema(live: LiveColumn[]): SchemaReport {
+  const problems: SchemaProblem[] = [];
+  for (const [table, columns] of Object.entries(expectedSchema)) {
+    const actual = live.filter((c: LiveColumn) => c.tbl === table);
+    if (actual.length === 0) {
+      problems.push({ kind: "missing_table", table });
+      continue;
+    }
+    // SQLite のカラム名は大文字小文字を区別しない
+    const byName = new Map<string, LiveColumn>(actual.map((c: LiveColumn) => [c.name.toLowerCase(), c]));
+    const expectedNames = new Set(columns.map((c: ExpectedColumn) => c.name.toLowerCase()));
+    columns.forEach((column: ExpectedColumn, cid: number) => {
+      const a = byName.get(column.name.toLowerCase());
+      if (!a) {
+        const renamed = actual.find((c: LiveColumn) => c.cid === cid && !expectedNames.has(c.name.toLowerCase()));
+        problems.push({ kind: "missing_column", table, column: column.name, renamedTo: renamed ? renamed.name : null });
+        return;
+      }
+      // 型の書き方の違いで誤検出しないように型のアフィニティで比較する
+      if (typeAffinity(a.type) !== typeAffinity(column.type)) {
+        problems.push({ kind: "type_mismatch", table, column: column.name, expected: column.type, actual: a.type });
+      }
+      // PRIMARY KEY のカラムは pragma_table_info では notnull にならないが sqlc は NOT NULL として扱う
+      const notNull = a.notnull !== 0 || a.pk > 0;
+      if (notNull !== column.notNull) {
+        problems.push({ kind: "nullability_mismatch", table, column: column.name, expected: column.notNull, actual: notNull });
+      }
+    });
+  }
+  return { ok: problems.length === 0, fingerprint: schemaFingerprint, problems };
+}
+/** 実際のデータベースのスキーマが sqlc が読み込んだスキーマと一致するかを調べる */
+export function verifySchema(d1: Pick<D1Database, "prepare">): Promise<SchemaReport> {
+  return d1
+    .prepare("SELECT t.value AS tbl, p.cid, p.name, p.type, p.\"notnull\", p.pk FROM json_each(?1) AS t JOIN pragma_table_info(t.value) AS p")
+    .bind(JSON.stringify(Object.keys(expectedSchema)))
+    .all<LiveColumn>()
+    .then((r: D1Result<LiveColumn>) => compareSchema(r.results ?? []));
+}
+`
@@ -1,3 +1,4 @@
+-- アカウントを id で取得する
 -- name: GetAccount :one
 SELECT * FROM account WHERE id = @account_id;
 
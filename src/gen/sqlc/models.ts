@@ -10,3 +10,17 @@ export type Account = {
   email: string | null;
 };
 
+export type NewAccount = {
+  pk?: number;
+  id: string;
+  displayName: string;
+  email?: string | null;
+};
+
+export type AccountUpdate = {
+  pk?: number;
+  id?: string;
+  displayName?: string;
+  email?: string | null;
+};
+
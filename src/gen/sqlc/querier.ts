@@ -3,9 +3,11 @@
 //   sqlc v1.25.0
 //   sqlc-gen-ts-d1 v0.0.0-a@169773bef3730638dff80a5736aa9ed510a77fa820d9e43eab692bfd794a73e1
 
-import { D1Database, D1PreparedStatement, D1Result } from "@cloudflare/workers-types/experimental"
-import { Account } from "./models"
+import type { D1Database, D1PreparedStatement, D1Result } from "@cloudflare/workers-types/experimental"
+import type { Account, NewAccount } from "./models"
 
+/** D1Database と D1Database.withSession() が返す D1DatabaseSession のどちらも受け付ける */
+export type D1Queryable = Pick<D1Database, "prepare">;
 type Query<T> = {
   then(onFulfilled?: (value: T) => void, onRejected?: (reason?: any) => void): void;
   batch(): D1PreparedStatement;
@@ -13,26 +15,25 @@ type Query<T> = {
 const getAccountQuery = `-- name: GetAccount :one
 SELECT pk, id, display_name, email FROM account WHERE id = ?1`;
 
+/** アカウントを id で取得する */
 export type GetAccountParams = {
   accountId: string;
 };
 
-export type GetAccountRow = {
-  pk: number;
-  id: string;
-  displayName: string;
-  email: string | null;
-};
-
-type RawGetAccountRow = {
-  pk: number;
-  id: string;
-  display_name: string;
-  email: string | null;
-};
+/** アカウントを id で取得する */
+export type GetAccountRow = Account;
 
+/**
+ * アカウントを id で取得する
+ *
+ * @param args.accountId
+ * @example
+ * ```sql
+ * SELECT pk, id, display_name, email FROM account WHERE id = ?1
+ * ```
+ */
 export function getAccount(
-  d1: D1Database,
+  d1: D1Queryable,
   args: GetAccountParams
 ): Query<GetAccountRow | null> {
   const ps = d1
@@ -40,13 +41,8 @@ export function getAccount(
     .bind(args.accountId);
   return {
     then(onFulfilled?: (value: GetAccountRow | null) => void, onRejected?: (reason?: any) => void) {
-      ps.first<RawGetAccountRow | null>()
-        .then((raw: RawGetAccountRow | null) => raw ? {
-          pk: raw.pk,
-          id: raw.id,
-          displayName: raw.display_name,
-          email: raw.email,
-        } : null)
+      ps.first<RawAccount | null>()
+        .then((raw: RawAccount | null) => raw ? fromRawAccount(raw) : null)
         .then(onFulfilled).catch(onRejected);
     },
     batch() { return ps; },
@@ -67,8 +63,14 @@ type RawListAccountsRow = {
   account_email: string | null;
 };
 
+/**
+ * @example
+ * ```sql
+ * SELECT account.pk, account.id, account.display_name, account.email FROM account
+ * ```
+ */
 export function listAccounts(
-  d1: D1Database
+  d1: D1Queryable
 ): Query<D1Result<ListAccountsRow>> {
   const ps = d1
     .prepare(listAccountsQuery);
@@ -97,19 +99,25 @@ const createAccountQuery = `-- name: CreateAccount :exec
 INSERT INTO account (id, display_name, email)
 VALUES (?1, ?2, ?3)`;
 
-export type CreateAccountParams = {
-  id: string;
-  displayName: string;
-  email: string | null;
-};
+export type CreateAccountParams = Pick<NewAccount, "id" | "displayName" | "email">;
 
+/**
+ * @param args.id
+ * @param args.displayName
+ * @param args.email
+ * @example
+ * ```sql
+ * INSERT INTO account (id, display_name, email)
+ * VALUES (?1, ?2, ?3)
+ * ```
+ */
 export function createAccount(
-  d1: D1Database,
+  d1: D1Queryable,
   args: CreateAccountParams
 ): Query<D1Result> {
   const ps = d1
     .prepare(createAccountQuery)
-    .bind(args.id, args.displayName, args.email);
+    .bind(args.id, args.displayName, args.email ?? null);
   return {
     then(onFulfilled?: (value: D1Result) => void, onRejected?: (reason?: any) => void) {
       ps.run()
@@ -130,22 +138,21 @@ export type UpdateAccountDisplayNameParams = {
   id: string;
 };
 
-export type UpdateAccountDisplayNameRow = {
-  pk: number;
-  id: string;
-  displayName: string;
-  email: string | null;
-};
-
-type RawUpdateAccountDisplayNameRow = {
-  pk: number;
-  id: string;
-  display_name: string;
-  email: string | null;
-};
+export type UpdateAccountDisplayNameRow = Account;
 
+/**
+ * @param args.displayName
+ * @param args.id
+ * @example
+ * ```sql
+ * UPDATE account
+ * SET display_name = ?1
+ * WHERE id = ?2
+ * RETURNING pk, id, display_name, email
+ * ```
+ */
 export function updateAccountDisplayName(
-  d1: D1Database,
+  d1: D1Queryable,
   args: UpdateAccountDisplayNameParams
 ): Query<UpdateAccountDisplayNameRow | null> {
   const ps = d1
@@ -153,13 +160,8 @@ export function updateAccountDisplayName(
     .bind(args.displayName, args.id);
   return {
     then(onFulfilled?: (value: UpdateAccountDisplayNameRow | null) => void, onRejected?: (reason?: any) => void) {
-      ps.first<RawUpdateAccountDisplayNameRow | null>()
-        .then((raw: RawUpdateAccountDisplayNameRow | null) => raw ? {
-          pk: raw.pk,
-          id: raw.id,
-          displayName: raw.display_name,
-          email: raw.email,
-        } : null)
+      ps.first<RawAccount | null>()
+        .then((raw: RawAccount | null) => raw ? fromRawAccount(raw) : null)
         .then(onFulfilled).catch(onRejected);
     },
     batch() { return ps; },
@@ -173,22 +175,17 @@ export type GetAccountsParams = {
   ids: string[];
 };
 
-export type GetAccountsRow = {
-  pk: number;
-  id: string;
-  displayName: string;
-  email: string | null;
-};
-
-type RawGetAccountsRow = {
-  pk: number;
-  id: string;
-  display_name: string;
-  email: string | null;
-};
+export type GetAccountsRow = Account;
 
+/**
+ * @param args.ids
+ * @example
+ * ```sql
+ * SELECT pk, id, display_name, email FROM account WHERE id IN (/*SLICE:ids*\/?)
+ * ```
+ */
 export function getAccounts(
-  d1: D1Database,
+  d1: D1Queryable,
   args: GetAccountsParams
 ): Query<D1Result<GetAccountsRow>> {
   let query = getAccountsQuery;
@@ -200,15 +197,10 @@ export function getAccounts(
     .bind(...params);
   return {
     then(onFulfilled?: (value: D1Result<GetAccountsRow>) => void, onRejected?: (reason?: any) => void) {
-      ps.all<RawGetAccountsRow>()
-        .then((r: D1Result<RawGetAccountsRow>) => { return {
+      ps.all<RawAccount>()
+        .then((r: D1Result<RawAccount>) => { return {
           ...r,
-          results: r.results.map((raw: RawGetAccountsRow) => { return {
-            pk: raw.pk,
-            id: raw.id,
-            displayName: raw.display_name,
-            email: raw.email,
-          }}),
+          results: r.results.map(fromRawAccount),
         }})
         .then(onFulfilled).catch(onRejected);
     },
@@ -227,8 +219,14 @@ type RawGetConnectionIdRow = {
   connection_id: string;
 };
 
+/**
+ * @example
+ * ```sql
+ * SELECT CAST(json_extract('{"connection_id":"foo"}', '$.connection_id') AS TEXT) AS connection_id
+ * ```
+ */
 export function getConnectionId(
-  d1: D1Database
+  d1: D1Queryable
 ): Query<GetConnectionIdRow | null> {
   const ps = d1
     .prepare(getConnectionIdQuery);
@@ -244,6 +242,22 @@ export function getConnectionId(
   }
 }
 
+type RawAccount = {
+  pk: number;
+  id: string;
+  display_name: string;
+  email: string | null;
+};
+
+function fromRawAccount(raw: RawAccount): Account {
+  return {
+    pk: raw.pk,
+    id: raw.id,
+    displayName: raw.display_name,
+    email: raw.email,
+  };
+}
+
 function expandedParam(n: number, len: number, last: number): string {
   const params: number[] = [n];
   for (let i = 1; i < len; i++) {